@@ -0,0 +1,233 @@
+// Copyright 2013 Joshua Tacoma. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zdcf
+
+// A ConfigBuilder builds a Config one setting at a time.  Each of its methods
+// returns a builder for the part of the configuration it adds, which also has
+// the methods of the builders above it, so that a whole configuration can be
+// written as one expression:
+//
+//	conf := zdcf.NewConfig().
+//		App("listener").
+//		Device("main", "zmq_queue").
+//		Socket("frontend", "ROUTER").Bind("tcp://*:5555").
+//		Socket("backend", "DEALER").Bind("tcp://*:5556")
+//	err := zdcf.ListenAndServe("listener", conf)
+//
+// Any of the builders can be used as a configuration source.
+type ConfigBuilder struct {
+	conf *Config
+}
+
+// NewConfig returns a builder for an empty ZDCF 1.0 configuration.
+func NewConfig() *ConfigBuilder {
+	return &ConfigBuilder{&Config{Version: 1.0}}
+}
+
+// Config returns the configuration that has been built.
+func (b *ConfigBuilder) Config() *Config { return b.conf }
+
+// App adds the named app, if it has not already been added, and returns a
+// builder for it.
+func (b *ConfigBuilder) App(name string) *AppBuilder {
+	if b.conf.Apps == nil {
+		b.conf.Apps = map[string]*AppConfig{}
+	}
+	appConf, ok := b.conf.Apps[name]
+	if !ok {
+		appConf = &AppConfig{}
+		b.conf.Apps[name] = appConf
+	}
+	return &AppBuilder{b, appConf}
+}
+
+// An AppBuilder builds the configuration of an app.
+type AppBuilder struct {
+	*ConfigBuilder
+	app *AppConfig
+}
+
+func (b *AppBuilder) context() *ContextConfig {
+	if b.app.Context == nil {
+		b.app.Context = &ContextConfig{}
+	}
+	return b.app.Context
+}
+
+// IoThreads sets the number of I/O threads for the app's context.
+func (b *AppBuilder) IoThreads(n int) *AppBuilder {
+	b.context().IoThreads = n
+	return b
+}
+
+// Verbose makes the app log what its devices do.
+func (b *AppBuilder) Verbose() *AppBuilder {
+	verbose := true
+	b.context().Verbose = &verbose
+	return b
+}
+
+// Linger sets the linger period, in milliseconds, for every socket the app
+// opens.
+func (b *AppBuilder) Linger(ms int) *AppBuilder {
+	b.context().Linger = &ms
+	return b
+}
+
+// Device adds the named device, if it has not already been added, sets its
+// type and returns a builder for it.
+func (b *AppBuilder) Device(name, typ string) *DeviceBuilder {
+	if b.app.Devices == nil {
+		b.app.Devices = map[string]*DeviceConfig{}
+	}
+	return b.device(b.app.Devices, name, typ)
+}
+
+// Template adds the named template, if it has not already been added, sets
+// its type and returns a builder for it.  The type may be left empty for
+// devices that extend the template to set.
+func (b *AppBuilder) Template(name, typ string) *DeviceBuilder {
+	if b.app.Templates == nil {
+		b.app.Templates = map[string]*DeviceConfig{}
+	}
+	return b.device(b.app.Templates, name, typ)
+}
+
+func (b *AppBuilder) device(devices map[string]*DeviceConfig, name, typ string) *DeviceBuilder {
+	devConf, ok := devices[name]
+	if !ok {
+		devConf = &DeviceConfig{}
+		devices[name] = devConf
+	}
+	devConf.Type = typ
+	return &DeviceBuilder{b, devConf}
+}
+
+// A DeviceBuilder builds the configuration of a device.
+type DeviceBuilder struct {
+	*AppBuilder
+	device *DeviceConfig
+}
+
+// Extends makes the device extend the named template or device.
+func (b *DeviceBuilder) Extends(name string) *DeviceBuilder {
+	b.device.Extends = name
+	return b
+}
+
+// Restart sets the device's restart policy.
+func (b *DeviceBuilder) Restart(restart RestartConfig) *DeviceBuilder {
+	b.device.Restart = &restart
+	return b
+}
+
+// Param sets one of the device's params.
+func (b *DeviceBuilder) Param(name string, value interface{}) *DeviceBuilder {
+	if b.device.Params == nil {
+		b.device.Params = map[string]interface{}{}
+	}
+	b.device.Params[name] = value
+	return b
+}
+
+// Socket adds the named socket, if it has not already been added, sets its
+// type and returns a builder for it.
+func (b *DeviceBuilder) Socket(name, typ string) *SocketBuilder {
+	if b.device.Sockets == nil {
+		b.device.Sockets = map[string]*SocketConfig{}
+	}
+	sockConf, ok := b.device.Sockets[name]
+	if !ok {
+		sockConf = &SocketConfig{}
+		b.device.Sockets[name] = sockConf
+	}
+	sockConf.Type = typ
+	return &SocketBuilder{b, sockConf}
+}
+
+// A SocketBuilder builds the configuration of a socket.
+type SocketBuilder struct {
+	*DeviceBuilder
+	socket *SocketConfig
+}
+
+func (b *SocketBuilder) options() *SocketOptions {
+	if b.socket.Options == nil {
+		b.socket.Options = &SocketOptions{}
+	}
+	return b.socket.Options
+}
+
+// Bind adds endpoints for the socket to bind to.
+func (b *SocketBuilder) Bind(endpoints ...string) *SocketBuilder {
+	b.socket.Bind = append(b.socket.Bind, endpoints...)
+	return b
+}
+
+// Connect adds endpoints for the socket to connect to.
+func (b *SocketBuilder) Connect(endpoints ...string) *SocketBuilder {
+	b.socket.Connect = append(b.socket.Connect, endpoints...)
+	return b
+}
+
+// Hwm sets the socket's high water mark.
+func (b *SocketBuilder) Hwm(n int) *SocketBuilder {
+	b.options().Hwm = n
+	return b
+}
+
+// Swap sets the size, in bytes, of the socket's swap space.
+func (b *SocketBuilder) Swap(n int) *SocketBuilder {
+	b.options().Swap = n
+	return b
+}
+
+// Affinity sets the socket's I/O thread affinity.
+func (b *SocketBuilder) Affinity(n int) *SocketBuilder {
+	b.options().Affinity = n
+	return b
+}
+
+// Identity sets the socket's identity.
+func (b *SocketBuilder) Identity(identity string) *SocketBuilder {
+	b.options().Identity = identity
+	return b
+}
+
+// Subscribe adds message filters to a SUB socket.
+func (b *SocketBuilder) Subscribe(filters ...string) *SocketBuilder {
+	b.options().Subscribe = append(b.options().Subscribe, filters...)
+	return b
+}
+
+// Rate sets the socket's multicast data rate, in kilobits per second.
+func (b *SocketBuilder) Rate(n int) *SocketBuilder {
+	b.options().Rate = n
+	return b
+}
+
+// RecoveryIvl sets the socket's multicast recovery interval.
+func (b *SocketBuilder) RecoveryIvl(n int) *SocketBuilder {
+	b.options().RecoveryIvl = n
+	return b
+}
+
+// McastLoop sets whether the socket receives its own multicast messages.
+func (b *SocketBuilder) McastLoop(loop bool) *SocketBuilder {
+	b.options().McastLoop = &loop
+	return b
+}
+
+// SndBuf sets the size, in bytes, of the socket's kernel send buffer.
+func (b *SocketBuilder) SndBuf(n int) *SocketBuilder {
+	b.options().SndBuf = n
+	return b
+}
+
+// RcvBuf sets the size, in bytes, of the socket's kernel receive buffer.
+func (b *SocketBuilder) RcvBuf(n int) *SocketBuilder {
+	b.options().RcvBuf = n
+	return b
+}
@@ -0,0 +1,98 @@
+// Copyright 2013 Joshua Tacoma. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zdcf
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewConfig(t *testing.T) {
+	built := NewConfig().
+		App("listener").Linger(0).
+		Device("main", "zmq_queue").Restart(RestartConfig{Policy: "always"}).
+		Socket("frontend", "ROUTER").Bind("tcp://eth0:5555", "tcp://eth1:5555").Hwm(1000).
+		Socket("backend", "DEALER").Connect("tcp://eth0:5556").
+		Device("news", "zmq_forwarder").
+		Socket("in", "SUB").Connect("tcp://eth0:5557").Subscribe("weather").Subscribe("sports").McastLoop(false).
+		Socket("out", "PUB").Bind("tcp://eth0:5558").
+		App("talker").IoThreads(2).Verbose().
+		Config()
+	parsed, err := unmarshalZdcf1([]byte(`{
+		"version": 1.0,
+		"apps": {
+			"listener": {
+				"context": {"linger": 0},
+				"devices": {
+					"main": {
+						"type": "zmq_queue",
+						"restart": {"policy": "always"},
+						"sockets": {
+							"frontend": {
+								"type": "ROUTER",
+								"option": {"hwm": 1000},
+								"bind": ["tcp://eth0:5555", "tcp://eth1:5555"]
+							},
+							"backend": {"type": "DEALER", "connect": "tcp://eth0:5556"}
+						}
+					},
+					"news": {
+						"type": "zmq_forwarder",
+						"sockets": {
+							"in": {
+								"type": "SUB",
+								"option": {"subscribe": ["weather", "sports"], "mcast_loop": false},
+								"connect": "tcp://eth0:5557"
+							},
+							"out": {"type": "PUB", "bind": "tcp://eth0:5558"}
+						}
+					}
+				}
+			},
+			"talker": {
+				"context": {"iothreads": 2, "verbose": true}
+			}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("failed to unmarshal: %s", err)
+	}
+	if !reflect.DeepEqual(built, parsed) {
+		text, _ := marshalJSON(built)
+		t.Errorf("built:\n%s", text)
+	}
+}
+
+func TestNewConfig_Source(t *testing.T) {
+	conf := NewConfig().
+		App("built").
+		Device("main", "zmq_streamer").
+		Socket("frontend", "PULL").Bind("tcp://127.0.0.1:5571").
+		Socket("backend", "PUSH").Bind("tcp://127.0.0.1:5572")
+	app, err := NewApp("built", conf)
+	if err != nil {
+		t.Fatalf("failed to create app: %s", err)
+	}
+	defer app.Close()
+	if devices := app.Devices(); len(devices) != 1 || devices[0].Type() != "zmq_streamer" {
+		t.Errorf("devices = %v", devices)
+	}
+	overlay := NewConfig().
+		App("built").
+		Device("main", "zmq_queue").
+		Socket("frontend", "ROUTER").
+		Socket("backend", "DEALER").
+		Config()
+	appConf, err := loadApp("built", conf, overlay)
+	if err != nil {
+		t.Fatalf("failed to load: %s", err)
+	}
+	if main := appConf.Devices["main"]; main.Type != "zmq_queue" || len(main.Sockets) != 2 {
+		t.Errorf("main = %v", main)
+	}
+	if err = Validate("built", NewConfig().App("built").Device("main", "")); err == nil {
+		t.Errorf("validated a device with no type")
+	}
+}
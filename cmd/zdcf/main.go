@@ -0,0 +1,163 @@
+// Copyright 2013 Joshua Tacoma. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command zdcf works with ZeroMQ Device Configuration Files.
+//
+// Usage:
+//
+//	zdcf migrate -app name [-format json|zpl|yaml|toml] [file ...]
+//	zdcf schema [-version 0|1] [file ...]
+//
+// The migrate command converts ZDCF 0.x files, in any format the zdcf package
+// reads, to one ZDCF 1.x document in which they configure the named app, and
+// writes it to standard output.  With no files, it reads standard input.  The
+// document is written in the format of the first file unless -format says
+// otherwise.  Anything that cannot be carried over is reported on standard
+// error.
+//
+// The schema command, with no files, writes the JSON Schema for ZDCF documents
+// of the given major version, 1 by default.  With files, it instead checks
+// each against the schema for its own version and reports every violation on
+// standard error.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/jtacoma/go-zdcf"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+const usage = `usage: zdcf migrate -app name [-format json|zpl|yaml|toml] [file ...]
+       zdcf schema [-version 0|1] [file ...]
+`
+
+// run runs the command with the given arguments, returning its exit status.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	switch args[0] {
+	case "migrate":
+		return migrate(args[1:], stdin, stdout, stderr)
+	case "schema":
+		return schema(args[1:], stdout, stderr)
+	}
+	fmt.Fprintf(stderr, "zdcf: unknown command %q\n%s", args[0], usage)
+	return 2
+}
+
+// A namedReader is text read from a file, with the file's name so that
+// problems in it can be reported.
+type namedReader struct {
+	*bytes.Reader
+	name string
+}
+
+func (r namedReader) Name() string { return r.name }
+
+func migrate(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	var (
+		app    = flags.String("app", "", "the name of the app the files configure")
+		format = flags.String("format", "", "json, zpl, yaml or toml (default: the format of the first file)")
+	)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if len(*app) == 0 {
+		fmt.Fprintf(stderr, "zdcf migrate: -app is required\n%s", usage)
+		return 2
+	}
+	var (
+		sources   []interface{}
+		first     []byte
+		firstName string
+	)
+	if flags.NArg() == 0 {
+		text, err := ioutil.ReadAll(stdin)
+		if err != nil {
+			fmt.Fprintf(stderr, "zdcf migrate: %s\n", err)
+			return 1
+		}
+		sources, first = append(sources, text), text
+	}
+	for i, name := range flags.Args() {
+		text, err := ioutil.ReadFile(name)
+		if err != nil {
+			fmt.Fprintf(stderr, "zdcf migrate: %s\n", err)
+			return 1
+		}
+		if i == 0 {
+			first, firstName = text, name
+		}
+		sources = append(sources, namedReader{bytes.NewReader(text), name})
+	}
+	if len(*format) == 0 {
+		*format = zdcf.DetectFormat(firstName, first)
+	}
+	conf, warnings, err := zdcf.Migrate(*app, sources...)
+	if err != nil {
+		fmt.Fprintf(stderr, "zdcf migrate: %s\n", err)
+		return 1
+	}
+	for _, warning := range warnings {
+		fmt.Fprintf(stderr, "zdcf migrate: warning: %s\n", warning)
+	}
+	text, err := zdcf.Marshal(conf, *format)
+	if err != nil {
+		fmt.Fprintf(stderr, "zdcf migrate: %s\n", err)
+		return 1
+	}
+	stdout.Write(text)
+	return 0
+}
+
+func schema(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("schema", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	version := flags.Int("version", 1, "the major version of ZDCF to describe")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		text, err := zdcf.JSONSchema(*version)
+		if err != nil {
+			fmt.Fprintf(stderr, "zdcf schema: %s\n", err)
+			return 1
+		}
+		stdout.Write(text)
+		return 0
+	}
+	var sources []interface{}
+	for _, name := range flags.Args() {
+		text, err := ioutil.ReadFile(name)
+		if err != nil {
+			fmt.Fprintf(stderr, "zdcf schema: %s\n", err)
+			return 1
+		}
+		sources = append(sources, namedReader{bytes.NewReader(text), name})
+	}
+	err := zdcf.ValidateSchema(sources...)
+	if violations, ok := err.(zdcf.SchemaErrors); ok {
+		for _, violation := range violations {
+			fmt.Fprintf(stderr, "zdcf schema: %s\n", violation)
+		}
+		return 1
+	} else if err != nil {
+		fmt.Fprintf(stderr, "zdcf schema: %s\n", err)
+		return 1
+	}
+	return 0
+}
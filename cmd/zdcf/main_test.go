@@ -0,0 +1,157 @@
+// Copyright 2013 Joshua Tacoma. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jtacoma/go-zdcf"
+)
+
+func TestMigrate(t *testing.T) {
+	stdin := strings.NewReader(`
+version = 0.1
+context
+    iothreads = 1
+main
+    type = zmq_queue
+    frontend
+        type = ROUTER
+        colour = blue
+        bind = tcp://eth0:5555
+    backend
+        type = DEALER
+        bind = tcp://eth0:5556`)
+	var stdout, stderr bytes.Buffer
+	if status := run([]string{"migrate", "-app", "listener"}, stdin, &stdout, &stderr); status != 0 {
+		t.Fatalf("status = %d: %s", status, stderr.String())
+	}
+	expected := `version = 1
+apps
+    listener
+        context
+            iothreads = 1
+        devices
+            main
+                type = zmq_queue
+                sockets
+                    backend
+                        type = DEALER
+                        bind = tcp://eth0:5556
+                    frontend
+                        type = ROUTER
+                        bind = tcp://eth0:5555
+`
+	if stdout.String() != expected {
+		t.Errorf("stdout:\n%s", stdout.String())
+	}
+	if stderr.String() != "zdcf migrate: warning: 9:9: main/frontend/colour: unknown key, not carried over.\n" {
+		t.Errorf("stderr:\n%s", stderr.String())
+	}
+}
+
+func TestMigrate_JSON(t *testing.T) {
+	stdin := strings.NewReader(`{
+		"version": 0.1,
+		"main": {
+			"type": "zmq_queue",
+			"frontend": {"type": "ROUTER", "bind": "tcp://eth0:5555", "colour": "blue"}
+		}
+	}`)
+	var stdout, stderr bytes.Buffer
+	if status := run([]string{"migrate", "-app", "listener"}, stdin, &stdout, &stderr); status != 0 {
+		t.Fatalf("status = %d: %s", status, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), `"frontend": {`) {
+		t.Errorf("stdout:\n%s", stdout.String())
+	}
+	if !strings.Contains(stderr.String(), "main/frontend/colour: unknown key") {
+		t.Errorf("stderr:\n%s", stderr.String())
+	}
+}
+
+func TestMigrate_YAML(t *testing.T) {
+	stdin := strings.NewReader(`
+version: 0.1
+main:
+  type: zmq_queue
+  frontend: {type: ROUTER, bind: "tcp://eth0:5555"}`)
+	var stdout, stderr bytes.Buffer
+	if status := run([]string{"migrate", "-app", "listener"}, stdin, &stdout, &stderr); status != 0 {
+		t.Fatalf("status = %d: %s", status, stderr.String())
+	}
+	expected := `version: 1
+apps:
+  listener:
+    devices:
+      main:
+        type: zmq_queue
+        sockets:
+          frontend:
+            type: ROUTER
+            bind:
+              - tcp://eth0:5555
+`
+	if stdout.String() != expected {
+		t.Errorf("stdout:\n%s", stdout.String())
+	}
+}
+
+func TestMigrate_Errors(t *testing.T) {
+	for _, test := range []struct {
+		args  []string
+		stdin string
+	}{
+		{[]string{}, ""},
+		{[]string{"upgrade"}, ""},
+		{[]string{"migrate"}, "version = 0.1"},
+		{[]string{"migrate", "-app", "listener"}, "version = 1.0"},
+		{[]string{"migrate", "-app", "listener", "-format", "ini"}, "version = 0.1"},
+		{[]string{"migrate", "-app", "listener", "/no/such/file"}, ""},
+		{[]string{"schema", "-version", "2"}, ""},
+		{[]string{"schema", "/no/such/file"}, ""},
+	} {
+		var stdout, stderr bytes.Buffer
+		if status := run(test.args, strings.NewReader(test.stdin), &stdout, &stderr); status == 0 {
+			t.Errorf("%v: succeeded:\n%s", test.args, stdout.String())
+		}
+	}
+}
+
+func TestSchema(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if status := run([]string{"schema", "-version", "0"}, nil, &stdout, &stderr); status != 0 {
+		t.Fatalf("status = %d: %s", status, stderr.String())
+	}
+	if expected, _ := zdcf.JSONSchema(0); stdout.String() != string(expected) {
+		t.Errorf("stdout:\n%s", stdout.String())
+	}
+	dir, err := ioutil.TempDir("", "zdcf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	good, bad := filepath.Join(dir, "good.zdcf"), filepath.Join(dir, "bad.json")
+	ioutil.WriteFile(good, []byte("version = 1.0\n"), 0644)
+	ioutil.WriteFile(bad, []byte(`{"version": 1.0, "apps": {"listener": {"colour": "blue"}}}`), 0644)
+	stdout.Reset()
+	if status := run([]string{"schema", good}, nil, &stdout, &stderr); status != 0 {
+		t.Errorf("status = %d: %s", status, stderr.String())
+	}
+	if status := run([]string{"schema", good, bad}, nil, &stdout, &stderr); status != 1 {
+		t.Errorf("status = %d", status)
+	}
+	if expected := "zdcf schema: " + bad + ": /apps/listener/colour: unknown key.\n"; stderr.String() != expected {
+		t.Errorf("stderr:\n%s", stderr.String())
+	}
+	if stdout.Len() > 0 {
+		t.Errorf("stdout:\n%s", stdout.String())
+	}
+}
@@ -5,59 +5,293 @@
 package zdcf
 
 import (
+	"errors"
 	"fmt"
+	"reflect"
 	"regexp"
+	"strings"
+	"time"
 
 	zmq "github.com/alecthomas/gozmq"
 )
 
+// DeviceFunc registers a device for all device types that match the pattern.
+//
+// Devices registered later take precedence over those registered earlier.
 func DeviceFunc(deviceTypePattern string, device func(*DeviceContext)) error {
-	if pattern, err := regexp.Compile(deviceTypePattern); err != nil {
+	return DeviceErrFunc(deviceTypePattern, func(dev *DeviceContext) error {
+		device(dev)
+		return nil
+	})
+}
+
+// DeviceErrFunc is like DeviceFunc for devices that can fail.  An error
+// returned by the device is reported by ListenAndServe.
+func DeviceErrFunc(deviceTypePattern string, device func(*DeviceContext) error) error {
+	return RegisterDevice(DeviceSpec{Pattern: deviceTypePattern, Run: device})
+}
+
+// A DeviceSpec describes a device, and what it needs to be configured with,
+// for RegisterDevice.  NewApp and Validate reject any device of a type that
+// matches the pattern if it lacks one of the required sockets, if one of its
+// sockets is of a type that is not allowed for it, or if its params cannot be
+// decoded, as by DeviceContext.Decode, into a value like Params.
+type DeviceSpec struct {
+	Pattern            string              // matches the device types the device implements
+	RequiredSockets    []string            // the names of the sockets it opens
+	AllowedSocketTypes map[string][]string // the types each named socket may have, if limited
+	Params             interface{}         // a struct, or pointer to one, like that its params are decoded into
+	Run                func(*DeviceContext) error
+}
+
+// RegisterDevice registers a device as DeviceErrFunc does, along with what it
+// needs to be configured with.
+func RegisterDevice(spec DeviceSpec) error {
+	pattern, err := regexp.Compile(spec.Pattern)
+	if err != nil {
 		return err
-	} else {
-		registry = append(registry, registration{pattern, device})
 	}
+	if spec.Run == nil {
+		return errors.New("device has nothing to run.")
+	}
+	for _, sockName := range sortedKeys(spec.AllowedSocketTypes) {
+		for _, sockType := range spec.AllowedSocketTypes[sockName] {
+			if _, ok := socketTypes[sockType]; !ok {
+				return fmt.Errorf("socket %s: unknown socket type: %s", sockName, sockType)
+			}
+		}
+	}
+	if spec.Params != nil {
+		t := reflect.TypeOf(spec.Params)
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			return fmt.Errorf("params must be described by a struct, not %s.", t)
+		}
+	}
+	registry = append(registry, registration{pattern, spec})
 	return nil
 }
 
-func builtinDevice(dev *DeviceContext) {
+// A DeviceError records the failure of a device.
+type DeviceError struct {
+	Device string // the name of the device
+	Err    error
+}
+
+func (e *DeviceError) Error() string {
+	return fmt.Sprintf("device %s: %s", e.Device, e.Err)
+}
+
+// DeviceErrors is the error returned by ListenAndServe when devices fail.
+type DeviceErrors []*DeviceError
+
+func (e DeviceErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// runDevice runs the device, turning a panic into an error.  Neither
+// termination of the ØMQ context nor an error returned after the device was
+// told to stop is considered a failure.
+func runDevice(device func(*DeviceContext) error, dev *DeviceContext) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+		if err == zmq.ETERM || dev.stopping() {
+			err = nil
+		}
+	}()
+	return device(dev)
+}
+
+const (
+	restartNever     = "never"
+	restartOnFailure = "on-failure"
+	restartAlways    = "always"
+)
+
+// A restartPolicy decides whether and when a device is restarted.
+type restartPolicy struct {
+	when        string
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	maxRestarts int
+}
+
+func newRestartPolicy(conf *RestartConfig) (restartPolicy, error) {
+	policy := restartPolicy{
+		when:       restartNever,
+		backoff:    100 * time.Millisecond,
+		maxBackoff: 30 * time.Second,
+	}
+	if conf == nil {
+		return policy, nil
+	}
+	switch conf.Policy {
+	case "":
+	case restartNever, restartOnFailure, restartAlways:
+		policy.when = conf.Policy
+	default:
+		return policy, fmt.Errorf("unknown restart policy: %s", conf.Policy)
+	}
+	if conf.Backoff < 0 || conf.MaxBackoff < 0 || conf.MaxRestarts < 0 {
+		return policy, errors.New("restart settings must not be negative.")
+	}
+	if conf.Backoff > 0 {
+		policy.backoff = time.Duration(conf.Backoff) * time.Millisecond
+	}
+	if conf.MaxBackoff > 0 {
+		policy.maxBackoff = time.Duration(conf.MaxBackoff) * time.Millisecond
+	}
+	if policy.maxBackoff < policy.backoff {
+		policy.maxBackoff = policy.backoff
+	}
+	policy.maxRestarts = conf.MaxRestarts
+	return policy, nil
+}
+
+// superviseDevice runs the device until its restart policy says it should not
+// be restarted or it is told to stop, and returns the error from its last run.
+//
+// Sockets left open by the device are closed after each run, so a restarted
+// device opens fresh sockets.
+func superviseDevice(device func(*DeviceContext) error, dev *DeviceContext) error {
 	var (
-		typ         zmq.DeviceType
+		policy   = dev.restart
+		delay    = policy.backoff
+		restarts int
+	)
+	for {
+		dev.setStatus(DeviceRunning, nil)
+		err := runDevice(device, dev)
+		dev.closeSockets()
+		if err != nil {
+			dev.app.logf("%s: failed: %s", dev.name, err)
+		}
+		if policy.when == restartNever ||
+			policy.when == restartOnFailure && err == nil ||
+			policy.maxRestarts > 0 && restarts >= policy.maxRestarts ||
+			dev.stopping() {
+			return dev.finish(err)
+		}
+		dev.setStatus(DeviceRestarting, err)
+		select {
+		case <-dev.Done():
+			return dev.finish(err)
+		case <-time.After(delay):
+		}
+		if dev.stopping() {
+			return dev.finish(err)
+		}
+		restarts++
+		dev.app.logf("%s: restarting (%d)", dev.name, restarts)
+		if delay *= 2; delay > policy.maxBackoff {
+			delay = policy.maxBackoff
+		}
+	}
+}
+
+// pollInterval is how long a builtin device waits for messages before
+// checking whether it has been told to stop.
+const pollInterval = 100 * time.Millisecond
+
+// builtinDevice implements the zmq_forwarder, zmq_streamer and zmq_queue
+// devices.  Unlike zmq.Device, it stops when the device is told to stop, even
+// if the app's ØMQ context is still in use by other devices.
+func builtinDevice(dev *DeviceContext) error {
+	var (
+		both        bool
 		back, front zmq.Socket
 		err         error
 	)
 	switch dev.Type() {
-	case "zmq_forwarder":
-		typ = zmq.FORWARDER
-	case "zmq_streamer":
-		typ = zmq.STREAMER
+	case "zmq_forwarder", "zmq_streamer":
+		both = false
 	case "zmq_queue":
-		typ = zmq.QUEUE
+		both = true
 	default:
-		panic(fmt.Sprintf("device has unknown type: %s.", dev.Type()))
+		return fmt.Errorf("device has unknown type: %s.", dev.Type())
+	}
+	if back, err = dev.Open("backend"); err != nil {
+		return err
 	}
-	back = dev.MustOpen("backend")
-	front = dev.MustOpen("frontend")
-	err = zmq.Device(typ, front, back)
-	//err = zmq.Proxy( front, back, capture)
+	if front, err = dev.Open("frontend"); err != nil {
+		return err
+	}
+	items := zmq.PollItems{{Socket: front, Events: zmq.POLLIN}}
+	if both {
+		items = append(items, zmq.PollItem{Socket: back, Events: zmq.POLLIN})
+	}
+	for !dev.stopping() {
+		if _, err = zmq.Poll(items, pollInterval); err != nil {
+			return err
+		}
+		if items[0].REvents&zmq.POLLIN != 0 {
+			if err = relay(front, back); err != nil {
+				return err
+			}
+		}
+		if both && items[1].REvents&zmq.POLLIN != 0 {
+			if err = relay(back, front); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// relay receives one message, including all its parts, and sends it on.
+func relay(from, to zmq.Socket) error {
+	parts, err := from.RecvMultipart(0)
 	if err != nil {
+		return err
 	}
+	return to.SendMultipart(parts, 0)
 }
 
 type registration struct {
 	pattern *regexp.Regexp
-	device  func(*DeviceContext)
+	spec    DeviceSpec
 }
 
 var registry = []registration{
-	{regexp.MustCompile(`zmq_[a-z0-9_]*`), builtinDevice},
+	builtinSpec(`zmq_[a-z0-9_]*`, nil, nil),
+	builtinSpec(`^zmq_queue$`, []string{"ROUTER", "XREP"}, []string{"DEALER", "XREQ"}),
+	builtinSpec(`^zmq_forwarder$`, []string{"SUB", "XSUB"}, []string{"PUB", "XPUB"}),
+	builtinSpec(`^zmq_streamer$`, []string{"PULL", "UPSTREAM"}, []string{"PUSH", "DOWNSTREAM"}),
+}
+
+// builtinSpec returns the registration of a builtin device, which needs a
+// frontend and a backend socket of the given types, or of any type if none
+// are given.
+func builtinSpec(pattern string, frontend, backend []string) registration {
+	spec := DeviceSpec{
+		Pattern:         pattern,
+		RequiredSockets: []string{"frontend", "backend"},
+		Run:             builtinDevice,
+	}
+	if frontend != nil || backend != nil {
+		spec.AllowedSocketTypes = map[string][]string{"frontend": frontend, "backend": backend}
+	}
+	return registration{regexp.MustCompile(pattern), spec}
+}
+
+func lookupDevice(typeName string) (func(*DeviceContext) error, bool) {
+	spec, ok := lookupSpec(typeName)
+	return spec.Run, ok
 }
 
-func lookupDevice(typeName string) (func(*DeviceContext), bool) {
+func lookupSpec(typeName string) (DeviceSpec, bool) {
 	for i := len(registry) - 1; i >= 0; i-- {
 		if registry[i].pattern.MatchString(typeName) {
-			return registry[i].device, true
+			return registry[i].spec, true
 		}
 	}
-	return nil, false
+	return DeviceSpec{}, false
 }
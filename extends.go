@@ -0,0 +1,71 @@
+// Copyright 2013 Joshua Tacoma. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zdcf
+
+import (
+	"fmt"
+	"strings"
+)
+
+// resolveExtends replaces each of the app's devices that extends a template or
+// another device with the result of merging it over what it extends.  A name
+// is looked up among the app's templates before its devices.  Devices that
+// cannot be resolved are reported and left out, so that the problems that
+// follow from that are not reported as well.
+func resolveExtends(appName string, appConf *AppConfig) (problems ValidationErrors) {
+	var (
+		resolved = map[string]*DeviceConfig{}
+		failed   = map[string]bool{}
+		resolve  func(key string, devConf *DeviceConfig, stack []string) *DeviceConfig
+	)
+	resolve = func(key string, devConf *DeviceConfig, stack []string) *DeviceConfig {
+		if len(devConf.Extends) == 0 {
+			return devConf
+		}
+		if result, ok := resolved[key]; ok {
+			return result
+		}
+		if failed[key] {
+			return nil
+		}
+		fail := func(format string, args ...interface{}) *DeviceConfig {
+			failed[key] = true
+			problems = append(problems, &ParseError{Key: key + "/extends", Err: fmt.Errorf(format, args...)})
+			return nil
+		}
+		for i, including := range stack {
+			if including == key {
+				return fail("extends cycle: %s", strings.Join(push(stack[i:], key), " -> "))
+			}
+		}
+		baseKey, base := "apps/"+appName+"/templates/"+devConf.Extends, appConf.Templates[devConf.Extends]
+		if base == nil {
+			baseKey, base = deviceKey(appName, devConf.Extends), appConf.Devices[devConf.Extends]
+		}
+		if base == nil {
+			return fail("no such template or device: %s", devConf.Extends)
+		}
+		if base = resolve(baseKey, base, push(stack, key)); base == nil {
+			if failed[key] {
+				return nil
+			}
+			return fail("cannot extend %s, which cannot be resolved.", devConf.Extends)
+		}
+		result := &DeviceConfig{}
+		result.update(base)
+		result.update(devConf)
+		result.Extends = devConf.Extends
+		resolved[key] = result
+		return result
+	}
+	devices := map[string]*DeviceConfig{}
+	for _, devName := range sortedKeys(appConf.Devices) {
+		if devConf := resolve(deviceKey(appName, devName), appConf.Devices[devName], nil); devConf != nil {
+			devices[devName] = devConf
+		}
+	}
+	appConf.Devices = devices
+	return problems
+}
@@ -0,0 +1,131 @@
+// Copyright 2013 Joshua Tacoma. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zdcf
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadApp_Extends(t *testing.T) {
+	appConf, err := loadApp("broker", []byte(`
+version = 1.0
+apps
+    broker
+        templates
+            queue
+                type = zmq_queue
+                restart
+                    policy = always
+                sockets
+                    frontend
+                        type = ROUTER
+                        option
+                            hwm = 1000
+                        bind = tcp://eth0:5555
+                    backend
+                        type = DEALER
+                        bind = tcp://eth0:5556
+        devices
+            orders
+                extends = queue
+                sockets
+                    frontend
+                        bind = tcp://eth0:6555
+                        bind+ = ipc://orders
+            refunds
+                extends = orders
+                sockets
+                    backend
+                        bind = tcp://eth0:7556
+                    monitor
+                        delete = true`))
+	if err != nil {
+		t.Fatalf("failed to load: %s", err)
+	}
+	if len(appConf.Devices) != 2 {
+		t.Fatalf("devices = %v", appConf.Devices)
+	}
+	for devName, expected := range map[string][]string{
+		"orders":  {"tcp://eth0:6555", "ipc://orders", "tcp://eth0:5556"},
+		"refunds": {"tcp://eth0:6555", "ipc://orders", "tcp://eth0:7556"},
+	} {
+		devConf := appConf.Devices[devName]
+		if devConf.Type != "zmq_queue" || devConf.Restart == nil || devConf.Restart.Policy != "always" {
+			t.Errorf("%s: %v", devName, devConf)
+			continue
+		}
+		frontend, backend := devConf.Sockets["frontend"], devConf.Sockets["backend"]
+		bind := append(joinList(frontend.Bind, frontend.BindAppend), joinList(backend.Bind, backend.BindAppend)...)
+		if !reflect.DeepEqual(bind, expected) {
+			t.Errorf("%s: bind = %v", devName, bind)
+		}
+		if frontend.Type != "ROUTER" || frontend.Options == nil || frontend.Options.Hwm != 1000 {
+			t.Errorf("%s: frontend = %v", devName, frontend)
+		}
+	}
+	if bind := appConf.Templates["queue"].Sockets["frontend"].Bind; !reflect.DeepEqual(bind, []string{"tcp://eth0:5555"}) {
+		t.Errorf("template changed: bind = %v", bind)
+	}
+}
+
+func TestLoadApp_ExtendsErrors(t *testing.T) {
+	_, err := loadApp("broker", []byte(`{
+		"version": 1.0,
+		"apps": {
+			"broker": {
+				"templates": {
+					"loop": {"extends": "loop"}
+				},
+				"devices": {
+					"a": {"extends": "b"},
+					"b": {"extends": "a"},
+					"c": {"extends": "b"},
+					"d": {"extends": "nothing"},
+					"e": {"extends": "loop"}
+				}
+			}
+		}
+	}`))
+	problems, ok := err.(ValidationErrors)
+	if !ok {
+		t.Fatalf("err = %v", err)
+	}
+	expected := []string{
+		"apps/broker/devices/a/extends: extends cycle: apps/broker/devices/a -> apps/broker/devices/b -> apps/broker/devices/a",
+		"apps/broker/devices/b/extends: cannot extend a, which cannot be resolved.",
+		"apps/broker/devices/c/extends: cannot extend b, which cannot be resolved.",
+		"apps/broker/devices/d/extends: no such template or device: nothing",
+		"apps/broker/templates/loop/extends: extends cycle: apps/broker/templates/loop -> apps/broker/templates/loop",
+		"apps/broker/devices/e/extends: cannot extend loop, which cannot be resolved.",
+	}
+	if len(problems) != len(expected) {
+		t.Fatalf("err = %s", err)
+	}
+	for i, problem := range problems {
+		if !strings.HasSuffix(problem.Error(), expected[i]) {
+			t.Errorf("%d: %s", i, problem)
+		}
+	}
+}
+
+func TestNewConfig_Template(t *testing.T) {
+	conf := NewConfig().
+		App("broker").
+		Template("queue", "zmq_queue").
+		Socket("frontend", "ROUTER").Bind("tcp://eth0:5555").
+		Socket("backend", "DEALER").Bind("tcp://eth0:5556").
+		Device("orders", "").Extends("queue").
+		Socket("frontend", "").Bind("tcp://eth0:6555")
+	appConf, err := loadApp("broker", conf)
+	if err != nil {
+		t.Fatalf("failed to load: %s", err)
+	}
+	if orders := appConf.Devices["orders"]; orders.Type != "zmq_queue" || len(orders.Sockets) != 2 ||
+		orders.Sockets["frontend"].Bind[0] != "tcp://eth0:6555" {
+		t.Errorf("orders = %v", orders)
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2013 Joshua Tacoma. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zdcf
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// RegisterFormat registers a configuration format, which is then read and
+// written wherever JSON and ZPL are: by NewApp, Validate, Migrate and Marshal,
+// and by the zdcf command.
+//
+// Text is read in the format named by its file's extension, as in .yaml, or
+// else in the first format whose sniff function, if it has one, recognizes the
+// text.  ZPL, whose files are named .zdcf or .zpl, recognizes any text, and so
+// comes last.  The decode function converts the text to the values that
+// encoding/json would decode from the equivalent JSON document: a
+// map[string]interface{} holding strings, numbers, bools, nils,
+// []interface{}s and more maps.  If it fails for a reason it can locate in the
+// text, its error should be a *ParseError.  The encode function writes a
+// configuration in the format.  Either may be nil for a format that is only
+// read or only written.
+//
+// Formats registered later take precedence over those registered earlier,
+// including over those of the same name.
+func RegisterFormat(name string, sniff func(text []byte) bool, decode func(text []byte) (interface{}, error), encode func(conf *Config) ([]byte, error)) {
+	formats = append(formats, format{name, sniff, decode, encode, false})
+}
+
+type format struct {
+	name   string
+	sniff  func([]byte) bool
+	decode func([]byte) (interface{}, error)
+	encode func(*Config) ([]byte, error)
+	native bool // read by unmarshal, which locates problems precisely
+}
+
+func sniffZPL([]byte) bool { return true }
+
+var formats = []format{
+	{"zpl", sniffZPL, nil, encodeZPL, true},
+	{"zdcf", nil, nil, encodeZPL, true},
+	{"json", isJSON, nil, encodeJSON, true},
+}
+
+// DetectFormat returns the name of the format in which text, read from the
+// named file, if any, will be read.
+func DetectFormat(name string, text []byte) string {
+	return findFormat(name, text).name
+}
+
+// findFormat returns the format of text read from the named file.
+func findFormat(name string, text []byte) format {
+	if ext := strings.TrimPrefix(filepath.Ext(name), "."); len(ext) > 0 {
+		if f, ok := lookupFormat(ext); ok && (f.native || f.decode != nil) {
+			return f
+		}
+	}
+	for i := len(formats) - 1; i >= 0; i-- {
+		if f := formats[i]; f.sniff != nil && (f.native || f.decode != nil) && f.sniff(text) {
+			return f
+		}
+	}
+	return formats[0]
+}
+
+func lookupFormat(name string) (format, bool) {
+	for i := len(formats) - 1; i >= 0; i-- {
+		if formats[i].name == name {
+			return formats[i], true
+		}
+	}
+	return format{}, false
+}
+
+// extensions returns the extensions of the files that Dir reads.
+func extensions() []string {
+	var (
+		exts []string
+		seen = map[string]bool{}
+	)
+	for _, f := range formats {
+		if !seen[f.name] && (f.native || f.decode != nil) {
+			exts, seen[f.name] = append(exts, f.name), true
+		}
+	}
+	return exts
+}
+
+// convert returns text read from the named file as JSON if it is in a format
+// other than JSON or ZPL, reporting whether it did so.  Positions in the
+// converted text are meaningless, so any problems found in it should be
+// reported without them.
+func convert(name string, text []byte) ([]byte, bool, error) {
+	d := findFormat(name, text)
+	if d.native {
+		return text, false, nil
+	}
+	v, err := d.decode(text)
+	if err != nil {
+		if _, ok := err.(*ParseError); ok {
+			return nil, false, err
+		}
+		return nil, false, &ParseError{Err: fmt.Errorf("%s: %s", d.name, err)}
+	}
+	if _, ok := v.(map[string]interface{}); !ok && v != nil {
+		return nil, false, &ParseError{Err: fmt.Errorf("%s: expected a mapping at the top level.", d.name)}
+	}
+	if v == nil {
+		v = map[string]interface{}{}
+	}
+	if text, err = json.Marshal(v); err != nil {
+		return nil, false, &ParseError{Err: fmt.Errorf("%s: %s", d.name, err)}
+	}
+	return text, true, nil
+}
+
+// withoutPosition removes the line and column from errors found in converted
+// text.
+func withoutPosition(err error) error {
+	switch e := err.(type) {
+	case *ParseError:
+		e.Line, e.Column = 0, 0
+	case ValidationErrors:
+		for _, problem := range e {
+			problem.Line, problem.Column = 0, 0
+		}
+	}
+	return err
+}
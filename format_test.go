@@ -0,0 +1,176 @@
+// Copyright 2013 Joshua Tacoma. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zdcf
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const formatsZPL = `
+version = 1.0
+apps
+    listener
+        context
+            linger = 0
+        devices
+            main
+                type = zmq_queue
+                sockets
+                    frontend
+                        type = ROUTER
+                        option
+                            hwm = 1000
+                        bind = tcp://eth0:5555
+                        bind = tcp://eth1:5555
+                    backend
+                        type = DEALER
+                        connect = tcp://eth0:5556`
+
+const formatsYAML = `
+version: 1.0
+apps:
+  listener:
+    context: {linger: 0}
+    devices:
+      main:
+        type: zmq_queue
+        sockets:
+          frontend:
+            type: ROUTER
+            option:
+              hwm: 1000
+            bind:
+              - tcp://eth0:5555
+              - tcp://eth1:5555
+          backend:
+            type: DEALER
+            connect: tcp://eth0:5556`
+
+const formatsTOML = `
+version = 1.0
+
+[apps.listener]
+context = { linger = 0 }
+
+[apps.listener.devices.main]
+type = "zmq_queue"
+
+[apps.listener.devices.main.sockets.frontend]
+type = "ROUTER"
+option.hwm = 1000
+bind = ["tcp://eth0:5555", "tcp://eth1:5555"]
+
+[apps.listener.devices.main.sockets.backend]
+type = "DEALER"
+connect = "tcp://eth0:5556"`
+
+func TestLoadApp_Formats(t *testing.T) {
+	expected, err := loadApp("listener", formatsZPL)
+	if err != nil {
+		t.Fatalf("failed to load ZPL: %s", err)
+	}
+	dir := writeFiles(t, map[string]string{
+		"listener.yaml": formatsYAML,
+		"listener.toml": formatsTOML,
+		"sniffed":       formatsYAML,
+	})
+	defer os.RemoveAll(dir)
+	for _, source := range []interface{}{
+		formatsYAML,
+		formatsTOML,
+		File(filepath.Join(dir, "listener.yaml")),
+		File(filepath.Join(dir, "listener.toml")),
+		File(filepath.Join(dir, "sniffed")),
+	} {
+		appConf, err := loadApp("listener", source)
+		if err != nil {
+			t.Errorf("%v: failed to load: %s", source, err)
+		} else if !reflect.DeepEqual(appConf, expected) {
+			t.Errorf("%v: got %v", source, appConf)
+		}
+	}
+	if _, err = loadApp("listener", Dir(dir)); err != nil {
+		t.Errorf("failed to load directory: %s", err)
+	}
+}
+
+func TestLoadApp_FormatErrors(t *testing.T) {
+	for _, test := range []struct {
+		text     string
+		expected string
+	}{
+		{"version: 1.0\napps:\n  listener:\n    colour: blue", "apps/listener/colour: unknown key."},
+		{"version: 1.0\napps:\n  listener:\n    context: {iothreads: two}", "apps/listener/context/iothreads: cannot use string as int"},
+		{"version = 1.0\n[apps.listener]\ncolour = 'blue'", "apps/listener/colour: unknown key."},
+		{"version: 1.0\napps: [listener]", "apps: cannot use array"},
+		{"version: 1.0\napps:\n  listener: x\n    y: 1", "4: mapping values are not allowed in this context."},
+		{"- version: 1.0", "yaml: expected a mapping at the top level."},
+	} {
+		_, err := loadApp("listener", test.text)
+		if err == nil {
+			t.Errorf("%q: loaded without error", test.text)
+		} else if err.Error() != test.expected && !strings.HasPrefix(err.Error(), test.expected) {
+			t.Errorf("%q: err = %s", test.text, err)
+		}
+	}
+}
+
+func TestRegisterFormat(t *testing.T) {
+	RegisterFormat("lines", nil, func(text []byte) (interface{}, error) {
+		conf := map[string]interface{}{"version": 1.0}
+		for _, line := range strings.Fields(string(text)) {
+			conf["apps"] = map[string]interface{}{line: map[string]interface{}{}}
+		}
+		return conf, nil
+	}, func(conf *Config) ([]byte, error) {
+		return []byte(strings.Join(sortedKeys(conf.Apps), "\n")), nil
+	})
+	RegisterFormat("report", nil, nil, func(conf *Config) ([]byte, error) {
+		return []byte("report"), nil
+	})
+	dir := writeFiles(t, map[string]string{"apps.lines": "listener", "apps.report": "listener"})
+	defer os.RemoveAll(dir)
+	if _, err := loadApp("listener", File(filepath.Join(dir, "apps.lines"))); err != nil {
+		t.Errorf("failed to load: %s", err)
+	}
+	if _, err := loadApp("listener", File(filepath.Join(dir, "apps.report"))); err == nil {
+		t.Errorf("read a format that can only be written")
+	}
+	if _, err := loadApp("listener", "listener"); err == nil {
+		t.Errorf("sniffed text for a format with no sniff function")
+	}
+	if text, err := Marshal(NewConfig().App("listener").Config(), "lines"); err != nil || string(text) != "listener" {
+		t.Errorf("Marshal: %q, %v", text, err)
+	}
+	if _, err := Marshal(NewConfig().Config(), "ini"); err == nil {
+		t.Errorf("marshalled in an unknown format")
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	for _, test := range []struct {
+		name, text, expected string
+	}{
+		{"", formatsZPL, "zpl"},
+		{"", formatsYAML, "yaml"},
+		{"", formatsTOML, "toml"},
+		{"", `{"version": 1.0}`, "json"},
+		{"", "{\n  \"apps\": {}\n}\n[", "json"},
+		{"listener.zdcf", formatsYAML, "zdcf"},
+		{"listener.yml", "version = 1.0", "yml"},
+		{"listener.conf", formatsTOML, "toml"},
+		{"", "version = 1.0\napps.listener.context.iothreads = 2", "toml"},
+		{"", "version = 1.0\napps\n    listener", "zpl"},
+		{"", "identity = a: b", "zpl"},
+	} {
+		if format := DetectFormat(test.name, []byte(test.text)); format != test.expected {
+			t.Errorf("%s %q: got %s", test.name, test.text, format)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2013 Joshua Tacoma. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zdcf
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// includes returns the configurations that conf includes, in the order they
+// are to be merged, followed by conf itself.  The name is that of the file
+// conf was read from, if any, and stack holds the absolute names of the files
+// that include it, to detect cycles.
+func includes(appName string, conf *Config, name string, stack []string) ([]*Config, ValidationErrors, error) {
+	var (
+		confs    []*Config
+		problems ValidationErrors
+	)
+	for _, path := range conf.Include {
+		text, included, err := readInclude(name, path, stack)
+		if err != nil {
+			return nil, nil, includeError(name, "include", err)
+		}
+		next, unknown, err := parseZdcf(appName, included, text)
+		if err != nil {
+			return nil, nil, sourceError(included, err)
+		}
+		problems = append(problems, withSource(included, unknown)...)
+		more, unknown, err := includes(appName, next, included, push(stack, included))
+		if err != nil {
+			return nil, nil, err
+		}
+		confs, problems = append(confs, more...), append(problems, unknown...)
+	}
+	for _, otherName := range sortedKeys(conf.Apps) {
+		appConf := conf.Apps[otherName]
+		if appConf == nil {
+			continue
+		}
+		fragments, unknown, err := appIncludes(appConf, "apps/"+otherName+"/include", name, stack)
+		if err != nil {
+			return nil, nil, err
+		}
+		problems = append(problems, unknown...)
+		for _, fragment := range fragments {
+			confs = append(confs, &Config{
+				Version: 1.0,
+				Apps:    map[string]*AppConfig{otherName: fragment},
+			})
+		}
+	}
+	return append(confs, conf), problems, nil
+}
+
+// appIncludes returns the app fragments that appConf includes, in the order
+// they are to be merged, much as includes does for whole documents.
+func appIncludes(appConf *AppConfig, key, name string, stack []string) ([]*AppConfig, ValidationErrors, error) {
+	var (
+		fragments []*AppConfig
+		problems  ValidationErrors
+	)
+	for _, path := range appConf.Include {
+		text, included, err := readInclude(name, path, stack)
+		if err != nil {
+			return nil, nil, includeError(name, key, err)
+		}
+		fragment, unknown, err := parseFragment(included, text)
+		if err != nil {
+			return nil, nil, sourceError(included, err)
+		}
+		problems = append(problems, withSource(included, unknown)...)
+		more, unknown, err := appIncludes(fragment, "include", included, push(stack, included))
+		if err != nil {
+			return nil, nil, err
+		}
+		fragments, problems = append(fragments, more...), append(problems, unknown...)
+		fragments = append(fragments, fragment)
+	}
+	return fragments, problems, nil
+}
+
+// parseFragment decodes an app's context and devices from text read from the
+// named file, in any supported format.
+func parseFragment(name string, text []byte) (*AppConfig, ValidationErrors, error) {
+	text, converted, err := convert(name, text)
+	if err != nil {
+		return nil, nil, err
+	}
+	var fragment AppConfig
+	if err = unmarshal(text, &fragment); err != nil {
+		if converted {
+			err = withoutPosition(err)
+		}
+		return nil, nil, err
+	}
+	problems := unknownKeys(text, reflect.TypeOf(fragment))
+	if converted {
+		withoutPosition(problems)
+	}
+	return &fragment, problems, nil
+}
+
+// readInclude reads the file at path, relative to the directory of the named
+// file that includes it, returning its text and its absolute name.
+func readInclude(name, path string, stack []string) ([]byte, string, error) {
+	if !filepath.IsAbs(path) && len(name) > 0 {
+		path = filepath.Join(filepath.Dir(name), path)
+	}
+	included, err := filepath.Abs(path)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, including := range stack {
+		if including == included {
+			return nil, "", fmt.Errorf("include cycle: %s",
+				strings.Join(push(stack, included), " -> "))
+		}
+	}
+	text, err := ioutil.ReadFile(included)
+	return text, included, err
+}
+
+func includeError(name, key string, err error) error {
+	return &ParseError{Source: name, Key: key, Err: err}
+}
+
+// push returns a new stack with name on top.
+func push(stack []string, name string) []string {
+	return append(stack[:len(stack):len(stack)], name)
+}
+
+func withSource(name string, problems ValidationErrors) ValidationErrors {
+	for _, problem := range problems {
+		problem.Source = name
+	}
+	return problems
+}
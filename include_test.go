@@ -0,0 +1,124 @@
+// Copyright 2013 Joshua Tacoma. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zdcf
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadApp_Include(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"listener.json": `{
+			"version": 1.0,
+			"include": "shared/base.zdcf",
+			"apps": {
+				"listener": {
+					"devices": {
+						"main": {"sockets": {"frontend": {"bind": "tcp://eth1:5555"}}}
+					}
+				}
+			}
+		}`,
+		"fleet.zdcf": `
+version = 1.0
+apps
+    east
+        include = shared/devices.zdcf
+    west
+        include = shared/devices.zdcf
+        context
+            iothreads = 2`,
+	})
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "shared"), 0755); err != nil {
+		t.Fatalf("failed to create directory: %s", err)
+	}
+	shared := writeFilesIn(t, filepath.Join(dir, "shared"), map[string]string{
+		"base.zdcf": `
+version = 1.0
+include = context.json
+apps
+    listener
+        devices
+            main
+                type = zmq_queue
+                sockets
+                    frontend
+                        type = ROUTER
+                        bind = tcp://eth0:5555
+                    backend
+                        type = DEALER
+                        connect = tcp://eth0:5556`,
+		"context.json": `{"version": 1.0, "apps": {"listener": {"context": {"linger": 0}}}}`,
+		"devices.zdcf": `
+context
+    iothreads = 1
+devices
+    main
+        type = zmq_streamer
+        sockets
+            frontend
+                type = PULL
+                bind = tcp://eth0:5555
+            backend
+                type = PUSH
+                connect = tcp://eth0:5556`,
+	})
+	appConf, err := loadApp("listener", File(filepath.Join(dir, "listener.json")))
+	if err != nil {
+		t.Fatalf("failed to load: %s", err)
+	}
+	frontend := appConf.Devices["main"].Sockets["frontend"]
+	if appConf.Devices["main"].Type != "zmq_queue" || frontend.Type != "ROUTER" || frontend.Bind[0] != "tcp://eth1:5555" {
+		t.Errorf("frontend = %v", frontend)
+	}
+	if appConf.Context == nil || appConf.Context.Linger == nil {
+		t.Errorf("context = %v", appConf.Context)
+	}
+	for appName, iothreads := range map[string]int{"east": 1, "west": 2} {
+		appConf, err := loadApp(appName, File(filepath.Join(dir, "fleet.zdcf")))
+		if err != nil {
+			t.Errorf("%s: failed to load: %s", appName, err)
+		} else if appConf.Devices["main"].Type != "zmq_streamer" || appConf.Context.IoThreads != iothreads {
+			t.Errorf("%s: %v", appName, appConf)
+		}
+	}
+	conf := NewConfig().App("listener").Config()
+	conf.Include = []string{filepath.Join(shared, "base.zdcf")}
+	if appConf, err = loadApp("listener", conf); err != nil {
+		t.Errorf("failed to load: %s", err)
+	} else if appConf.Devices["main"] == nil {
+		t.Errorf("did not include base.zdcf")
+	}
+}
+
+func TestLoadApp_IncludeErrors(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.zdcf":      "version = 1.0\ninclude = b.zdcf",
+		"b.zdcf":      "version = 1.0\ninclude = c.zdcf",
+		"c.zdcf":      "version = 1.0\ninclude = a.zdcf",
+		"self.zdcf":   "version = 1.0\napps\n    x\n        include = self.zdcf",
+		"lost.zdcf":   "version = 1.0\ninclude = missing.zdcf",
+		"bad.zdcf":    "version = 1.0\ninclude = broken.zdcf",
+		"broken.zdcf": "version = 1.0\napps\n  x",
+	})
+	defer os.RemoveAll(dir)
+	for name, expected := range map[string]string{
+		"a.zdcf":    "include cycle: " + filepath.Join(dir, "a.zdcf") + " -> " + filepath.Join(dir, "b.zdcf"),
+		"self.zdcf": "apps/x/include: include cycle",
+		"lost.zdcf": "lost.zdcf: include: open",
+		"bad.zdcf":  filepath.Join(dir, "broken.zdcf") + ":3:",
+	} {
+		_, err := loadApp("x", File(filepath.Join(dir, name)))
+		if err == nil {
+			t.Errorf("%s: loaded without error", name)
+		} else if !strings.Contains(err.Error(), expected) {
+			t.Errorf("%s: err = %s", name, err)
+		}
+	}
+}
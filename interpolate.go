@@ -0,0 +1,148 @@
+// Copyright 2013 Joshua Tacoma. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zdcf
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Params is a configuration source that gives values for the variables in the
+// others.  A string value in the configuration may refer to a variable as
+// ${NAME}, or as ${NAME:-default} to use the default if the variable is unset
+// or empty.  Variables not given by any Params are looked up in the
+// environment, and $${ stands for a literal ${.
+type Params map[string]string
+
+// expand replaces the variables in s with their values.
+func expand(s string, lookup func(string) (string, bool)) (string, error) {
+	var (
+		result []string
+		rest   = s
+	)
+	for {
+		i := strings.Index(rest, "${")
+		if i < 0 {
+			break
+		}
+		if i > 0 && rest[i-1] == '$' {
+			result = append(result, rest[:i], "{")
+			rest = rest[i+2:]
+			continue
+		}
+		end := strings.IndexByte(rest[i:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated variable in %q", s)
+		}
+		name := rest[i+2 : i+end]
+		fallback, hasFallback := "", false
+		if sep := strings.Index(name, ":-"); sep >= 0 {
+			name, fallback, hasFallback = name[:sep], name[sep+2:], true
+		}
+		if !isVariableName(name) {
+			return "", fmt.Errorf("invalid variable name %q in %q", name, s)
+		}
+		value, ok := lookup(name)
+		if len(value) == 0 && hasFallback {
+			value, ok = fallback, true
+		}
+		if !ok {
+			return "", fmt.Errorf("unresolved variable %s in %q", name, s)
+		}
+		result = append(result, rest[:i], value)
+		rest = rest[i+end+1:]
+	}
+	return strings.Join(append(result, rest), ""), nil
+}
+
+func isVariableName(name string) bool {
+	for i, c := range name {
+		if !(c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || i > 0 && '0' <= c && c <= '9') {
+			return false
+		}
+	}
+	return len(name) > 0
+}
+
+// lookupVariable returns a function that looks variables up in params and
+// then in the environment.
+func lookupVariable(params Params) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		if value, ok := params[name]; ok {
+			return value, true
+		}
+		return os.LookupEnv(name)
+	}
+}
+
+// interpolate expands the variables in every string in the configuration v,
+// reporting each that cannot be expanded under its path.  Lists are copied
+// before they are changed, since they may be shared with a configuration
+// source.  Strings held in maps and interfaces, as params are, are replaced
+// rather than changed in place, which is not possible.
+func interpolate(v reflect.Value, path string, lookup func(string) (string, bool)) (problems ValidationErrors) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			problems = interpolate(v.Elem(), path, lookup)
+		}
+	case reflect.Interface:
+		if v.IsNil() {
+			break
+		}
+		elem := reflect.New(v.Elem().Type()).Elem()
+		elem.Set(v.Elem())
+		problems = interpolate(elem, path, lookup)
+		if v.CanSet() {
+			v.Set(elem)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			name := strings.Split(v.Type().Field(i).Tag.Get("json"), ",")[0]
+			problems = append(problems, interpolate(v.Field(i), path+"/"+name, lookup)...)
+		}
+	case reflect.Map:
+		for _, name := range sortedKeys(v.Interface()) {
+			key := reflect.ValueOf(name).Convert(v.Type().Key())
+			if kind := v.Type().Elem().Kind(); kind != reflect.String && kind != reflect.Interface {
+				problems = append(problems, interpolate(v.MapIndex(key), path+"/"+name, lookup)...)
+				continue
+			}
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(v.MapIndex(key))
+			problems = append(problems, interpolate(elem, path+"/"+name, lookup)...)
+			v.SetMapIndex(key, elem)
+		}
+	case reflect.Slice:
+		if kind := v.Type().Elem().Kind(); kind != reflect.String && kind != reflect.Interface || v.Len() == 0 {
+			break
+		}
+		list := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		reflect.Copy(list, v)
+		for i := 0; i < list.Len(); i++ {
+			problems = append(problems, interpolate(list.Index(i), path+"/"+strconv.Itoa(i), lookup)...)
+		}
+		v.Set(list)
+	case reflect.String:
+		if !strings.Contains(v.String(), "${") {
+			break
+		}
+		if value, err := expand(v.String(), lookup); err != nil {
+			problems = append(problems, &ParseError{Key: strings.TrimPrefix(path, "/"), Err: err})
+		} else if v.CanSet() {
+			v.SetString(value)
+		} else {
+			problems = append(problems, &ParseError{
+				Key: strings.TrimPrefix(path, "/"),
+				Err: errors.New("cannot substitute variables here."),
+			})
+		}
+	}
+	return problems
+}
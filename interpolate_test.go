@@ -0,0 +1,94 @@
+// Copyright 2013 Joshua Tacoma. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zdcf
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestExpand(t *testing.T) {
+	vars := map[string]string{"HOST": "eth0", "EMPTY": ""}
+	lookup := func(name string) (string, bool) {
+		value, ok := vars[name]
+		return value, ok
+	}
+	for s, expected := range map[string]string{
+		"tcp://${HOST}:5555":          "tcp://eth0:5555",
+		"tcp://${HOST}:${PORT:-5555}": "tcp://eth0:5555",
+		"tcp://${EMPTY:-lo}:5555":     "tcp://lo:5555",
+		"${HOST}${HOST}":              "eth0eth0",
+		"ipc:///tmp/$${HOST}":         "ipc:///tmp/${HOST}",
+		"$HOST and {HOST}":            "$HOST and {HOST}",
+		"${PORT:-}":                   "",
+		"${HOST:-a:-b}":               "eth0",
+		"tcp://${MISSING}:5555":       "unresolved variable MISSING",
+		"tcp://${HOST:5555":           "unterminated variable",
+		"tcp://${HOST-NAME}:5555":     "invalid variable name",
+		"tcp://${}:5555":              "invalid variable name",
+		"tcp://${1HOST}:5555":         "invalid variable name",
+	} {
+		value, err := expand(s, lookup)
+		if err != nil {
+			value = err.Error()
+		}
+		if !strings.Contains(value, expected) {
+			t.Errorf("%s: %s, expected %s", s, value, expected)
+		}
+	}
+}
+
+func TestLoadApp_Params(t *testing.T) {
+	os.Setenv("ZDCF_TEST_BACKEND", "tcp://127.0.0.1:5556")
+	defer os.Unsetenv("ZDCF_TEST_BACKEND")
+	conf := NewConfig().
+		App("listener").
+		Device("main", "${DEVICE_TYPE:-zmq_queue}").
+		Socket("frontend", "ROUTER").Bind("tcp://${HOST}:${PORT:-5555}").Identity("${HOST}-frontend").
+		Socket("backend", "DEALER").Connect("${ZDCF_TEST_BACKEND}")
+	appConf, err := loadApp("listener", conf, Params{"HOST": "lo"}, Params{"HOST": "eth0"})
+	if err != nil {
+		t.Fatalf("failed to load: %s", err)
+	}
+	main := appConf.Devices["main"]
+	if main.Type != "zmq_queue" {
+		t.Errorf("main.Type = %s", main.Type)
+	}
+	if frontend := main.Sockets["frontend"]; frontend.Bind[0] != "tcp://eth0:5555" || frontend.Options.Identity != "eth0-frontend" {
+		t.Errorf("frontend = %v", frontend)
+	}
+	if backend := main.Sockets["backend"]; backend.Connect[0] != "tcp://127.0.0.1:5556" {
+		t.Errorf("backend = %v", backend)
+	}
+	if bind := conf.Config().Apps["listener"].Devices["main"].Sockets["frontend"].Bind[0]; bind != "tcp://${HOST}:${PORT:-5555}" {
+		t.Errorf("source was changed: %s", bind)
+	}
+	_, err = loadApp("listener", conf)
+	problems, ok := err.(ValidationErrors)
+	if !ok || len(problems) != 2 {
+		t.Fatalf("err = %v", err)
+	}
+	for _, problem := range problems {
+		if !strings.Contains(problem.Error(), "unresolved variable HOST") ||
+			!strings.HasPrefix(problem.Key, "apps/listener/devices/main/sockets/frontend/") {
+			t.Errorf("problem = %s", problem)
+		}
+	}
+}
+
+func TestLoadApp_EscapedVariables(t *testing.T) {
+	conf := NewConfig().
+		App("listener").
+		Device("main", "zmq_queue").
+		Socket("frontend", "$${FRONTEND_TYPE}").Bind("tcp://$${HOST}").
+		Socket("backend", "DEALER").Connect("tcp://127.0.0.1:5556")
+	_, err := loadApp("listener", conf, Params{"FRONTEND_TYPE": "ROUTER", "HOST": "eth0:5555"})
+	expected := "apps/listener/devices/main/sockets/frontend/type: unknown socket type: ${FRONTEND_TYPE}; " +
+		`apps/listener/devices/main/sockets/frontend/bind: malformed endpoint "tcp://${HOST}": `
+	if err == nil || !strings.HasPrefix(err.Error(), expected) {
+		t.Errorf("err = %v", err)
+	}
+}
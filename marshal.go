@@ -0,0 +1,214 @@
+// Copyright 2013 Joshua Tacoma. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zdcf
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Marshal encodes a configuration in the named format: "json", "zpl" (or
+// "zdcf"), "yaml" (or "yml"), "toml" or any other that has been registered
+// with RegisterFormat.  The result of the built-in formats is canonical: the
+// same configuration is always written the same way, and reading it back gives
+// an equal configuration but for the types of params: ZPL reads every param
+// as a string, and each of the others reads numbers as its own type.  A device
+// that reads its params through DeviceContext sees the same values either way.
+func Marshal(conf *Config, name string) ([]byte, error) {
+	f, ok := lookupFormat(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown format: %s", name)
+	}
+	if f.encode == nil {
+		return nil, fmt.Errorf("format %s cannot be written.", name)
+	}
+	return f.encode(conf)
+}
+
+func encodeJSON(conf *Config) ([]byte, error) { return marshalJSON(conf) }
+
+func encodeZPL(conf *Config) ([]byte, error) { return marshalZPL(conf) }
+
+// marshalJSON encodes v, usually a *Config, as indented JSON.  Struct fields
+// are written in the order they are declared and map keys in sorted order, so
+// the same configuration is always written the same way.  Settings that are not
+// given, including empty lists and maps, are left out, so decoding the result
+// gives back a value equal to v wherever v came from decoding in the first
+// place.
+func marshalJSON(v interface{}) ([]byte, error) {
+	text, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+	return append(text, '\n'), nil
+}
+
+// marshalZPL encodes v, usually a *Config, as ZPL, in the same order and with
+// the same guarantee as marshalJSON.  A nil device or socket is written as a
+// section containing delete = true, ZPL having no equivalent of null.
+func marshalZPL(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeZPL(&buf, reflect.ValueOf(v), 0); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeZPL writes the fields of a struct, or the entries of a map, at the
+// given depth of indentation.
+func writeZPL(buf *bytes.Buffer, v reflect.Value, depth int) error {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			name := t.Field(i).Tag.Get("zpl")
+			if len(name) == 0 || name == "-" || name == "*" {
+				continue
+			}
+			if err := writeZPLEntry(buf, name, v.Field(i), depth); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		keys := v.MapKeys()
+		names := make([]string, len(keys))
+		for i, key := range keys {
+			names[i] = key.String()
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			value := v.MapIndex(reflect.ValueOf(name).Convert(v.Type().Key()))
+			if value.Kind() == reflect.Ptr && value.IsNil() {
+				if err := writeZPLName(buf, name, depth); err != nil {
+					return err
+				}
+				buf.WriteByte('\n')
+				writeZPLIndent(buf, depth+1)
+				buf.WriteString("delete = true\n")
+				continue
+			}
+			if err := writeZPLEntry(buf, name, value, depth); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("cannot write %s as a ZPL section.", v.Type())
+	}
+	return nil
+}
+
+// writeZPLEntry writes a named value, which may be a section, a list of
+// values (written as a repeated name), or a single value.  Zero values, other
+// than those pointed to, are not written.
+func writeZPLEntry(buf *bytes.Buffer, name string, v reflect.Value, depth int) error {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		elem := v.Elem()
+		if elem.Kind() == reflect.Struct || elem.Kind() == reflect.Map {
+			return writeZPLEntry(buf, name, elem, depth)
+		}
+		return writeZPLValue(buf, name, elem, depth)
+	case reflect.Struct:
+		if err := writeZPLName(buf, name, depth); err != nil {
+			return err
+		}
+		buf.WriteByte('\n')
+		return writeZPL(buf, v, depth+1)
+	case reflect.Map:
+		if v.Len() == 0 {
+			return nil
+		}
+		if err := writeZPLName(buf, name, depth); err != nil {
+			return err
+		}
+		buf.WriteByte('\n')
+		return writeZPL(buf, v, depth+1)
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if err := writeZPLValue(buf, name, v.Index(i), depth); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if v.IsZero() {
+		return nil
+	}
+	return writeZPLValue(buf, name, v, depth)
+}
+
+// writeZPLValue writes name = value, quoting the value if it would otherwise
+// be read differently.
+func writeZPLValue(buf *bytes.Buffer, name string, v reflect.Value, depth int) error {
+	var value string
+	switch v.Kind() {
+	case reflect.String:
+		value = v.String()
+	case reflect.Bool:
+		value = strconv.FormatBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		value = strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		value = strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		value = strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits())
+	default:
+		return fmt.Errorf("cannot write %s as a ZPL value.", v.Type())
+	}
+	if value != strings.TrimSpace(value) || len(value) == 0 ||
+		strings.Contains(value, " #") || strings.ContainsAny(value[:1], `"'#`) {
+		switch {
+		case !strings.Contains(value, `"`):
+			value = `"` + value + `"`
+		case !strings.Contains(value, `'`):
+			value = `'` + value + `'`
+		default:
+			return fmt.Errorf("%s: cannot quote %q in ZPL.", name, value)
+		}
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return fmt.Errorf("%s: cannot write %q in ZPL.", name, value)
+	}
+	if err := writeZPLName(buf, name, depth); err != nil {
+		return err
+	}
+	buf.WriteString(" = ")
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+	return nil
+}
+
+func writeZPLName(buf *bytes.Buffer, name string, depth int) error {
+	for i := 0; i < len(name); i++ {
+		if !isZPLNameChar(name[i]) {
+			return fmt.Errorf("%q cannot be written as a ZPL name.", name)
+		}
+	}
+	if len(name) == 0 {
+		return errors.New("an empty name cannot be written in ZPL.")
+	}
+	writeZPLIndent(buf, depth)
+	buf.WriteString(name)
+	return nil
+}
+
+func writeZPLIndent(buf *bytes.Buffer, depth int) {
+	buf.WriteString(strings.Repeat("    ", depth))
+}
@@ -0,0 +1,223 @@
+// Copyright 2013 Joshua Tacoma. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zdcf
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMarshal_RoundTrip(t *testing.T) {
+	conf, err := unmarshalZdcf1([]byte(`{
+		"version": 1.25,
+		"apps": {
+			"listener": {
+				"context": {"iothreads": 2, "verbose": true, "linger": 0},
+				"devices": {
+					"main": {
+						"type": "zmq_forwarder",
+						"restart": {"policy": "on-failure", "backoff": 50, "max_restarts": 3},
+						"sockets": {
+							"frontend": {
+								"type": "SUB",
+								"option": {
+									"hwm": 1000,
+									"identity": " padded # and quoted ",
+									"mcast_loop": false,
+									"subscribe": ["", "weather"],
+									"subscribe+": "news"
+								},
+								"bind": "tcp://eth0:5555",
+								"bind+": ["ipc:///tmp/frontend"]
+							},
+							"backend": {
+								"type": "PUB",
+								"option": {},
+								"connect": ["tcp://eth0:5556", "tcp://eth1:5556"]
+							}
+						}
+					},
+					"other": {"type": "zmq_queue", "restart": {}}
+				}
+			},
+			"talker": {}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("failed to unmarshal: %s", err)
+	}
+	for _, name := range []string{"json", "zpl", "yaml", "toml"} {
+		marshal := func(conf *Config) ([]byte, error) { return Marshal(conf, name) }
+		text, err := marshal(conf)
+		if err != nil {
+			t.Errorf("%s: failed to marshal: %s", name, err)
+			continue
+		}
+		converted, _, err := convert("conf."+name, text)
+		if err != nil {
+			t.Errorf("%s: failed to convert:\n%s\n%s", name, text, err)
+			continue
+		}
+		again, err := unmarshalZdcf1(converted)
+		if err != nil {
+			t.Errorf("%s: failed to unmarshal:\n%s\n%s", name, text, err)
+			continue
+		}
+		if !reflect.DeepEqual(conf, again) {
+			t.Errorf("%s: round trip changed the configuration:\n%s", name, text)
+		}
+		if text2, _ := marshal(again); string(text2) != string(text) {
+			t.Errorf("%s: not stable:\n%s\n%s", name, text, text2)
+		}
+	}
+}
+
+func TestMarshal_Canonical(t *testing.T) {
+	linger := 0
+	conf := &Config{
+		Version: 1,
+		Apps: map[string]*AppConfig{
+			"listener": {
+				Context: &ContextConfig{Linger: &linger},
+				Devices: map[string]*DeviceConfig{
+					"main": {
+						Type: "zmq_queue",
+						Sockets: map[string]*SocketConfig{
+							"frontend": {Type: "ROUTER", Bind: []string{"tcp://*:5555"}},
+							"backend":  {Type: "DEALER", Bind: []string{"tcp://*:5556", "ipc:///tmp/backend"}},
+						},
+					},
+					"old": nil,
+				},
+			},
+		},
+	}
+	expectedZPL := `version = 1
+apps
+    listener
+        context
+            linger = 0
+        devices
+            main
+                type = zmq_queue
+                sockets
+                    backend
+                        type = DEALER
+                        bind = tcp://*:5556
+                        bind = ipc:///tmp/backend
+                    frontend
+                        type = ROUTER
+                        bind = tcp://*:5555
+            old
+                delete = true
+`
+	if text, err := marshalZPL(conf); err != nil {
+		t.Errorf("failed to marshal ZPL: %s", err)
+	} else if string(text) != expectedZPL {
+		t.Errorf("ZPL:\n%s", text)
+	}
+	expectedJSON := `{
+    "version": 1,
+    "apps": {
+        "listener": {
+            "context": {
+                "linger": 0
+            },
+            "devices": {
+                "main": {
+                    "type": "zmq_queue",
+                    "sockets": {
+                        "backend": {
+                            "type": "DEALER",
+                            "bind": [
+                                "tcp://*:5556",
+                                "ipc:///tmp/backend"
+                            ]
+                        },
+                        "frontend": {
+                            "type": "ROUTER",
+                            "bind": [
+                                "tcp://*:5555"
+                            ]
+                        }
+                    }
+                },
+                "old": null
+            }
+        }
+    }
+}
+`
+	if text, err := marshalJSON(conf); err != nil {
+		t.Errorf("failed to marshal JSON: %s", err)
+	} else if string(text) != expectedJSON {
+		t.Errorf("JSON:\n%s", text)
+	}
+	expectedYAML := `version: 1
+apps:
+  listener:
+    context:
+      linger: 0
+    devices:
+      main:
+        type: zmq_queue
+        sockets:
+          backend:
+            type: DEALER
+            bind:
+              - tcp://*:5556
+              - ipc:///tmp/backend
+          frontend:
+            type: ROUTER
+            bind:
+              - tcp://*:5555
+      old: null
+`
+	if text, err := Marshal(conf, "yaml"); err != nil {
+		t.Errorf("failed to marshal YAML: %s", err)
+	} else if string(text) != expectedYAML {
+		t.Errorf("YAML:\n%s", text)
+	}
+	expectedTOML := `version = 1
+
+[apps.listener.context]
+linger = 0
+
+[apps.listener.devices.main]
+type = "zmq_queue"
+
+[apps.listener.devices.main.sockets.backend]
+type = "DEALER"
+bind = ["tcp://*:5556", "ipc:///tmp/backend"]
+
+[apps.listener.devices.main.sockets.frontend]
+type = "ROUTER"
+bind = ["tcp://*:5555"]
+
+[apps.listener.devices.old]
+delete = true
+`
+	if text, err := Marshal(conf, "toml"); err != nil {
+		t.Errorf("failed to marshal TOML: %s", err)
+	} else if string(text) != expectedTOML {
+		t.Errorf("TOML:\n%s", text)
+	}
+}
+
+func TestMarshalZPL_Errors(t *testing.T) {
+	for _, conf := range []*Config{
+		{Version: 1, Apps: map[string]*AppConfig{"my app": {}}},
+		{Version: 1, Apps: map[string]*AppConfig{"listener": {
+			Devices: map[string]*DeviceConfig{"main": {Type: "line\nbreak"}},
+		}}},
+		{Version: 1, Apps: map[string]*AppConfig{"listener": {
+			Devices: map[string]*DeviceConfig{"main": {Type: `"both" and 'both'`}},
+		}}},
+	} {
+		if text, err := marshalZPL(conf); err == nil {
+			t.Errorf("marshalled without error:\n%s", text)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2013 Joshua Tacoma. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zdcf
+
+import (
+	"errors"
+	"reflect"
+)
+
+// Migrate converts ZDCF 0.x sources, of any kind NewApp accepts except for a
+// *Config, to a ZDCF 1.x document in which they are the configuration of the
+// named app.  Several sources are merged as they would be by NewApp.
+//
+// Anything in the sources that cannot be carried over to the new document,
+// such as keys that are not part of ZDCF 0.x, is returned as a warning.
+func Migrate(appName string, sources ...interface{}) (conf *Config, warnings []*ParseError, err error) {
+	if len(sources) == 0 {
+		return nil, nil, errors.New("no configuration sources.")
+	}
+	conf = &Config{Version: 1.0}
+	for _, source := range sources {
+		texts, ok, err := readSource(source)
+		if !ok {
+			return nil, nil, errors.New("unsupported configuration source.")
+		} else if err != nil {
+			return nil, nil, err
+		}
+		for _, text := range texts {
+			conf0, unknown, err := migrateText(text.name, text.text)
+			if err != nil {
+				return nil, nil, sourceError(text.name, err)
+			}
+			for _, warning := range unknown {
+				warning.Source = text.name
+				warning.Err = errors.New("unknown key, not carried over.")
+			}
+			warnings = append(warnings, unknown...)
+			if err = conf.update(conf0.config(appName)); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return conf, warnings, nil
+}
+
+// migrateText decodes ZDCF 0.x text, in any supported format, read from the
+// named file, returning any unknown keys in it.
+func migrateText(name string, text []byte) (*zdcf0, ValidationErrors, error) {
+	text, converted, err := convert(name, text)
+	if err != nil {
+		return nil, nil, err
+	}
+	conf0, problems, err := decodeZdcf0(text)
+	if converted {
+		withoutPosition(problems)
+		if err != nil {
+			err = withoutPosition(err)
+		}
+	}
+	return conf0, problems, err
+}
+
+// decodeZdcf0 decodes ZDCF 0.x text in JSON or ZPL as migrateText does.
+func decodeZdcf0(text []byte) (*zdcf0, ValidationErrors, error) {
+	version, err := readVersion(text)
+	if err != nil {
+		return nil, nil, err
+	}
+	if version >= 1 {
+		err := versionError(text, version).(*ParseError)
+		err.Err = errors.New("already ZDCF 1.x, nothing to migrate.")
+		return nil, nil, err
+	}
+	conf0, err := unmarshalZdcf0(text)
+	if err != nil {
+		return nil, nil, err
+	}
+	return conf0, unknownKeys(text, reflect.TypeOf(conf0)), nil
+}
@@ -0,0 +1,37 @@
+// Copyright 2013 Joshua Tacoma. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zdcf
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	conf, warnings, err := Migrate("listener", `
+version = 0.1
+main
+    type = zmq_queue
+    frontend
+        type = ROUTER
+        bind = tcp://eth0:5555`, `{
+		"main": {
+			"frontend": {"bind+": "tcp://eth1:5555", "hmw": 10}
+		}
+	}`)
+	if err != nil {
+		t.Fatalf("failed to migrate: %s", err)
+	}
+	frontend := conf.Apps["listener"].Devices["main"].Sockets["frontend"]
+	if frontend.Type != "ROUTER" || len(frontend.Bind) != 1 || len(frontend.BindAppend) != 1 {
+		t.Errorf("frontend = %v", frontend)
+	}
+	if len(warnings) != 1 || warnings[0].Key != "main/frontend/hmw" {
+		t.Errorf("warnings = %v", warnings)
+	}
+	if _, _, err = Migrate("listener", "version = 1.0"); err == nil || !strings.Contains(err.Error(), "already") {
+		t.Errorf("err = %v", err)
+	}
+}
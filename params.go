@@ -0,0 +1,338 @@
+// Copyright 2013 Joshua Tacoma. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zdcf
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// updateParams merges the params in other into params and returns the result,
+// which is a new map if params was nil.  Values are copied from other, so the
+// result can be changed without changing any configuration source.  A null
+// param is kept, rather than removing the one before it, so that it also hides
+// any param of a device that is extended.
+func updateParams(params, other map[string]interface{}) map[string]interface{} {
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+	for name, value := range other {
+		switch value := value.(type) {
+		case map[string]interface{}:
+			section, _ := params[name].(map[string]interface{})
+			params[name] = updateParams(section, value)
+		default:
+			params[name] = copyParam(value)
+		}
+	}
+	return params
+}
+
+func copyParam(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		return updateParams(nil, value)
+	case []interface{}:
+		list := make([]interface{}, len(value))
+		for i, item := range value {
+			list[i] = copyParam(item)
+		}
+		return list
+	}
+	return value
+}
+
+// param returns the device's param of the given name, if it is set.
+func (d *DeviceContext) param(name string) (interface{}, bool) {
+	if d.conf == nil {
+		return nil, false
+	}
+	value, ok := d.conf.Params[name]
+	return value, ok && value != nil
+}
+
+// String returns the device's param of the given name as a string, or def if
+// it is not set.  Numbers and bools are formatted as they would be in ZPL.
+func (d *DeviceContext) String(name, def string) (string, error) {
+	value, ok := d.param(name)
+	if !ok {
+		return def, nil
+	}
+	s, ok := paramString(value)
+	if !ok {
+		return def, paramError(name, value, "string")
+	}
+	return s, nil
+}
+
+// Int returns the device's param of the given name as an int, or def if it is
+// not set.
+func (d *DeviceContext) Int(name string, def int) (int, error) {
+	value, ok := d.param(name)
+	if !ok {
+		return def, nil
+	}
+	i, ok := paramInt(value)
+	if !ok || int64(int(i)) != i {
+		return def, paramError(name, value, "int")
+	}
+	return int(i), nil
+}
+
+// Duration returns the device's param of the given name as a duration, or def
+// if it is not set.  A duration is given as a string such as "1.5s", as read
+// by time.ParseDuration, or as a number of milliseconds, like the other
+// durations in ZDCF.
+func (d *DeviceContext) Duration(name string, def time.Duration) (time.Duration, error) {
+	value, ok := d.param(name)
+	if !ok {
+		return def, nil
+	}
+	duration, ok := paramDuration(value)
+	if !ok {
+		return def, paramError(name, value, "duration")
+	}
+	return duration, nil
+}
+
+// Bool returns the device's param of the given name as a bool, or def if it is
+// not set.
+func (d *DeviceContext) Bool(name string, def bool) (bool, error) {
+	value, ok := d.param(name)
+	if !ok {
+		return def, nil
+	}
+	b, ok := paramBool(value)
+	if !ok {
+		return def, paramError(name, value, "bool")
+	}
+	return b, nil
+}
+
+// Decode stores the device's params in the struct that v points to.  Each
+// param is stored in the field whose json tag names it, or else whose name
+// matches it regardless of case, as with encoding/json; a param that matches
+// no field is an error.  Values are converted as by String, Int, Duration and
+// Bool, so a field of any of those types can be read from ZPL.  Fields whose
+// params are not set are left as they are, so they can hold defaults.
+func (d *DeviceContext) Decode(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("params can only be decoded into a pointer to a struct.")
+	}
+	var params map[string]interface{}
+	if d.conf != nil {
+		params = d.conf.Params
+	}
+	if err := decodeParam(params, rv.Elem(), ""); err != nil {
+		return err
+	}
+	return nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// decodeParam stores value, the param at path, in v.  The key of any error is
+// the path of the param it concerns.
+func decodeParam(value interface{}, v reflect.Value, path string) *ParseError {
+	if value == nil {
+		return nil
+	}
+	var ok bool
+	switch {
+	case v.Kind() == reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return decodeParam(value, v.Elem(), path)
+	case v.Type() == durationType:
+		var duration time.Duration
+		if duration, ok = paramDuration(value); ok {
+			v.SetInt(int64(duration))
+		}
+	case v.Kind() == reflect.Struct:
+		var section map[string]interface{}
+		if section, ok = value.(map[string]interface{}); ok {
+			for _, name := range sortedKeys(section) {
+				field, found := paramField(v, name)
+				if !found {
+					return &ParseError{Key: strings.TrimPrefix(path+"/"+name, "/"), Err: errors.New("unknown param.")}
+				}
+				if err := decodeParam(section[name], field, path+"/"+name); err != nil {
+					return err
+				}
+			}
+		}
+	case v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String:
+		var section map[string]interface{}
+		if section, ok = value.(map[string]interface{}); ok {
+			if v.IsNil() {
+				v.Set(reflect.MakeMap(v.Type()))
+			}
+			for _, name := range sortedKeys(section) {
+				elem := reflect.New(v.Type().Elem()).Elem()
+				if err := decodeParam(section[name], elem, path+"/"+name); err != nil {
+					return err
+				}
+				v.SetMapIndex(reflect.ValueOf(name).Convert(v.Type().Key()), elem)
+			}
+		}
+	case v.Kind() == reflect.Slice:
+		// a single value may be given in place of a list of them
+		list, isList := value.([]interface{})
+		if !isList {
+			list = []interface{}{value}
+		}
+		items := reflect.MakeSlice(v.Type(), len(list), len(list))
+		for i, item := range list {
+			if err := decodeParam(item, items.Index(i), path+"/"+strconv.Itoa(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(items)
+		ok = true
+	case v.Kind() == reflect.Interface && v.NumMethod() == 0:
+		v.Set(reflect.ValueOf(copyParam(value)))
+		ok = true
+	case v.Kind() == reflect.String:
+		var s string
+		if s, ok = paramString(value); ok {
+			v.SetString(s)
+		}
+	case v.Kind() == reflect.Bool:
+		var b bool
+		if b, ok = paramBool(value); ok {
+			v.SetBool(b)
+		}
+	case reflect.Int <= v.Kind() && v.Kind() <= reflect.Int64:
+		var i int64
+		if i, ok = paramInt(value); ok && !v.OverflowInt(i) {
+			v.SetInt(i)
+		} else {
+			ok = false
+		}
+	case reflect.Uint <= v.Kind() && v.Kind() <= reflect.Uint64:
+		var i int64
+		if i, ok = paramInt(value); ok && i >= 0 && !v.OverflowUint(uint64(i)) {
+			v.SetUint(uint64(i))
+		} else {
+			ok = false
+		}
+	case v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64:
+		var f float64
+		if f, ok = paramFloat(value); ok {
+			v.SetFloat(f)
+		}
+	}
+	if !ok {
+		return paramError(strings.TrimPrefix(path, "/"), value, v.Type().String())
+	}
+	return nil
+}
+
+// paramField returns the field of the struct v in which the named param is
+// stored.
+func paramField(v reflect.Value, name string) (reflect.Value, bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if field := t.Field(i); len(field.PkgPath) == 0 && strings.Split(field.Tag.Get("json"), ",")[0] == name {
+			return v.Field(i), true
+		}
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if field := t.Field(i); len(field.PkgPath) == 0 && len(field.Tag.Get("json")) == 0 && strings.EqualFold(field.Name, name) {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+func paramError(name string, value interface{}, typ string) *ParseError {
+	err := &ParseError{Key: name, Err: fmt.Errorf("cannot use %v as %s.", value, typ)}
+	if s, ok := value.(string); ok {
+		err.Err = fmt.Errorf("cannot use %q as %s.", s, typ)
+	}
+	return err
+}
+
+func paramString(value interface{}) (string, bool) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), true
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), true
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits()), true
+	}
+	return "", false
+}
+
+func paramInt(value interface{}) (int64, bool) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.String:
+		i, err := strconv.ParseInt(v.String(), 10, 64)
+		return i, err == nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint()), v.Uint() <= math.MaxInt64
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		return int64(f), f == math.Trunc(f) && math.Abs(f) < 1<<63
+	}
+	return 0, false
+}
+
+func paramFloat(value interface{}) (float64, bool) {
+	if s, ok := value.(string); ok {
+		f, err := strconv.ParseFloat(s, 64)
+		return f, err == nil
+	}
+	if i, ok := paramInt(value); ok {
+		return float64(i), true
+	}
+	v := reflect.ValueOf(value)
+	if v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64 {
+		return v.Float(), true
+	}
+	return 0, false
+}
+
+func paramBool(value interface{}) (bool, bool) {
+	switch value := value.(type) {
+	case bool:
+		return value, true
+	case string:
+		b, err := strconv.ParseBool(value)
+		return b, err == nil
+	}
+	return false, false
+}
+
+func paramDuration(value interface{}) (time.Duration, bool) {
+	switch value := value.(type) {
+	case time.Duration:
+		return value, true
+	case string:
+		if duration, err := time.ParseDuration(value); err == nil {
+			return duration, true
+		}
+	}
+	if ms, ok := paramFloat(value); ok {
+		return time.Duration(ms * float64(time.Millisecond)), true
+	}
+	return 0, false
+}
@@ -0,0 +1,228 @@
+// Copyright 2013 Joshua Tacoma. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zdcf
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoadApp_DeviceParams(t *testing.T) {
+	base := `{
+		"version": 1.0,
+		"apps": {
+			"indexer": {
+				"templates": {
+					"worker": {"type": "indexer", "params": {"batch": 100, "verbose": true, "store": {"path": "/var/index", "sync": "1s"}}}
+				},
+				"devices": {
+					"main": {"extends": "worker", "params": {"timeout": 2500, "tags": ["a", "b"], "verbose": null}}
+				}
+			}
+		}
+	}`
+	overlay := `
+version = 1.0
+apps
+    indexer
+        devices
+            main
+                params
+                    batch = 500
+                    name = ${NAME}
+                    store
+                        path = ${ROOT}/index`
+	source := &Config{
+		Version: 1,
+		Apps: map[string]*AppConfig{"indexer": {Devices: map[string]*DeviceConfig{
+			"main": {Params: map[string]interface{}{"retries": 3, "tags": []interface{}{"${NAME}"}}},
+		}}},
+	}
+	appConf, err := loadApp("indexer", base, overlay, source, Params{"NAME": "primary", "ROOT": "/srv"})
+	if err != nil {
+		t.Fatalf("failed to load: %s", err)
+	}
+	expected := map[string]interface{}{
+		"batch":   "500",
+		"name":    "primary",
+		"retries": 3,
+		"store":   map[string]interface{}{"path": "/srv/index", "sync": "1s"},
+		"tags":    []interface{}{"primary"},
+		"timeout": 2500.0,
+		"verbose": nil,
+	}
+	if params := appConf.Devices["main"].Params; !reflect.DeepEqual(params, expected) {
+		t.Errorf("params = %v", params)
+	}
+	if tags := source.Apps["indexer"].Devices["main"].Params["tags"]; !reflect.DeepEqual(tags, []interface{}{"${NAME}"}) {
+		t.Errorf("source changed: %v", tags)
+	}
+	if _, err = loadApp("indexer", base, overlay); err == nil {
+		t.Errorf("succeeded without NAME and ROOT")
+	} else if err.Error() != `apps/indexer/devices/main/params/name: unresolved variable NAME in "${NAME}"; apps/indexer/devices/main/params/store/path: unresolved variable ROOT in "${ROOT}/index"` {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestDeviceContext_Params(t *testing.T) {
+	d := &DeviceContext{conf: &DeviceConfig{Params: map[string]interface{}{
+		"batch":    "500",
+		"retries":  3.0,
+		"fraction": 0.5,
+		"verbose":  "true",
+		"quiet":    false,
+		"timeout":  2500.0,
+		"interval": "1m30s",
+		"delay":    "250",
+		"name":     "primary",
+		"store":    map[string]interface{}{"path": "/srv/index"},
+		"gone":     nil,
+	}}}
+	if s, err := d.String("name", "x"); s != "primary" || err != nil {
+		t.Errorf("name = %q, %v", s, err)
+	}
+	if s, err := d.String("retries", "x"); s != "3" || err != nil {
+		t.Errorf("retries = %q, %v", s, err)
+	}
+	if s, err := d.String("gone", "x"); s != "x" || err != nil {
+		t.Errorf("gone = %q, %v", s, err)
+	}
+	if i, err := d.Int("batch", 1); i != 500 || err != nil {
+		t.Errorf("batch = %d, %v", i, err)
+	}
+	if i, err := d.Int("retries", 1); i != 3 || err != nil {
+		t.Errorf("retries = %d, %v", i, err)
+	}
+	if i, err := d.Int("missing", 7); i != 7 || err != nil {
+		t.Errorf("missing = %d, %v", i, err)
+	}
+	if b, err := d.Bool("verbose", false); !b || err != nil {
+		t.Errorf("verbose = %v, %v", b, err)
+	}
+	if b, err := d.Bool("quiet", true); b || err != nil {
+		t.Errorf("quiet = %v, %v", b, err)
+	}
+	for name, expected := range map[string]time.Duration{
+		"timeout":  2500 * time.Millisecond,
+		"interval": 90 * time.Second,
+		"delay":    250 * time.Millisecond,
+		"missing":  time.Minute,
+	} {
+		if duration, err := d.Duration(name, time.Minute); duration != expected || err != nil {
+			t.Errorf("%s = %s, %v", name, duration, err)
+		}
+	}
+	for name, err := range map[string]error{
+		"name":     func() error { _, err := d.Int("name", 0); return err }(),
+		"fraction": func() error { _, err := d.Int("fraction", 0); return err }(),
+		"batch":    func() error { _, err := d.Bool("batch", false); return err }(),
+		"verbose":  func() error { _, err := d.Duration("verbose", 0); return err }(),
+		"store":    func() error { _, err := d.String("store", ""); return err }(),
+	} {
+		if err == nil {
+			t.Errorf("%s: succeeded", name)
+		}
+	}
+	if _, err := d.Int("name", 0); err == nil || err.Error() != `name: cannot use "primary" as int.` {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if s, err := (&DeviceContext{}).String("name", "x"); s != "x" || err != nil {
+		t.Errorf("no params: %q, %v", s, err)
+	}
+}
+
+func TestDeviceContext_Decode(t *testing.T) {
+	type store struct {
+		Path string
+		Sync time.Duration `json:"sync_every"`
+	}
+	var settings struct {
+		Batch    int
+		Retries  uint8
+		Fraction float32
+		Verbose  bool
+		Timeout  time.Duration
+		Tags     []string
+		Store    *store
+		Labels   map[string]string
+		Extra    interface{}
+		Keep     string
+	}
+	settings.Keep = "default"
+	d := &DeviceContext{conf: &DeviceConfig{Params: map[string]interface{}{
+		"batch":    "500",
+		"retries":  3.0,
+		"fraction": "0.5",
+		"verbose":  "true",
+		"timeout":  "2s",
+		"tags":     "a",
+		"store":    map[string]interface{}{"path": "/srv/index", "sync_every": 100.0},
+		"labels":   map[string]interface{}{"zone": "b", "rack": 7.0},
+		"extra":    []interface{}{"x", 1.0},
+	}}}
+	if err := d.Decode(&settings); err != nil {
+		t.Fatalf("failed to decode: %s", err)
+	}
+	if settings.Batch != 500 || settings.Retries != 3 || settings.Fraction != 0.5 || !settings.Verbose ||
+		settings.Timeout != 2*time.Second || !reflect.DeepEqual(settings.Tags, []string{"a"}) ||
+		settings.Store == nil || *settings.Store != (store{"/srv/index", 100 * time.Millisecond}) ||
+		!reflect.DeepEqual(settings.Labels, map[string]string{"zone": "b", "rack": "7"}) ||
+		!reflect.DeepEqual(settings.Extra, []interface{}{"x", 1.0}) || settings.Keep != "default" {
+		t.Errorf("settings = %+v", settings)
+	}
+	for params, expected := range map[string]map[string]interface{}{
+		"colour: unknown param.":                  {"colour": "blue"},
+		"store/sync: unknown param.":              {"store": map[string]interface{}{"sync": "1s"}},
+		`retries: cannot use "many" as uint8.`:    {"retries": "many"},
+		"retries: cannot use 300 as uint8.":       {"retries": 300.0},
+		`tags/1: cannot use map[] as string.`:     {"tags": []interface{}{"a", map[string]interface{}{}}},
+		`store: cannot use "/srv" as zdcf.store.`: {"store": "/srv"},
+	} {
+		d := &DeviceContext{conf: &DeviceConfig{Params: expected}}
+		if err := d.Decode(&settings); err == nil || err.Error() != params {
+			t.Errorf("%v: got %v, expected %s", expected, err, params)
+		}
+	}
+	if err := d.Decode(settings); err == nil {
+		t.Errorf("decoded into a struct rather than a pointer")
+	}
+}
+
+func TestNewConfig_Params(t *testing.T) {
+	conf := NewConfig().
+		App("indexer").
+		Device("main", "indexer").Param("batch", 500).Param("store", map[string]interface{}{"path": "/srv"}).
+		Socket("in", "PULL").Bind("tcp://*:5555").
+		Config()
+	appConf, err := loadApp("indexer", conf)
+	if err != nil {
+		t.Fatalf("failed to load: %s", err)
+	}
+	expected := map[string]interface{}{"batch": 500, "store": map[string]interface{}{"path": "/srv"}}
+	if params := appConf.Devices["main"].Params; !reflect.DeepEqual(params, expected) {
+		t.Errorf("params = %v", params)
+	}
+	for _, format := range []string{"json", "zpl", "yaml", "toml"} {
+		text, err := Marshal(conf, format)
+		if err != nil {
+			t.Errorf("%s: %s", format, err)
+			continue
+		}
+		appConf, err := loadApp("indexer", text)
+		if err != nil {
+			t.Errorf("%s: %s\n%s", format, err, text)
+			continue
+		}
+		d := &DeviceContext{conf: appConf.Devices["main"]}
+		var settings struct {
+			Batch int
+			Store struct{ Path string }
+		}
+		if err = d.Decode(&settings); err != nil || settings.Batch != 500 || settings.Store.Path != "/srv" {
+			t.Errorf("%s: %+v, %v\n%s", format, settings, err, text)
+		}
+	}
+}
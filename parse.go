@@ -0,0 +1,523 @@
+// Copyright 2013 Joshua Tacoma. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zdcf
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jtacoma/go-zpl"
+)
+
+// A ParseError describes a problem with the text of a configuration source.
+type ParseError struct {
+	Source string // the name of the file, if known
+	Line   int    // the line number, starting at 1, or 0 if unknown
+	Column int    // the column number, starting at 1, or 0 if unknown
+	Key    string // the path to the offending key, e.g. apps/echo/devices
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	var message string
+	if len(e.Source) > 0 {
+		message = e.Source + ":"
+	}
+	if e.Line > 0 && e.Column > 0 {
+		message += fmt.Sprintf("%d:%d:", e.Line, e.Column)
+	} else if e.Line > 0 {
+		message += fmt.Sprintf("%d:", e.Line)
+	}
+	if len(message) > 0 {
+		message += " "
+	}
+	if len(e.Key) > 0 {
+		message += e.Key + ": "
+	}
+	return message + e.Err.Error()
+}
+
+// isJSON reports whether text looks like JSON rather than ZPL, which is when
+// its first non-space character opens an object.
+func isJSON(text []byte) bool {
+	text = bytes.TrimLeft(text, " \t\r\n")
+	return len(text) > 0 && text[0] == '{'
+}
+
+// unmarshal decodes text, in JSON or ZPL, into v.
+func unmarshal(text []byte, v interface{}) error {
+	if isJSON(text) {
+		if err := checkJSON(text, reflect.TypeOf(v)); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(text, v); err != nil {
+			return jsonError(text, err)
+		}
+		return nil
+	}
+	root, err := scanZPL(text)
+	if err != nil {
+		return err
+	}
+	if err = checkZPL(root, reflect.TypeOf(v), ""); err != nil {
+		return err
+	}
+	if err = zpl.Unmarshal(text, v); err != nil {
+		return &ParseError{Err: err}
+	}
+	return nil
+}
+
+// readVersion reads the ZDCF version of text, returning 0 if there is none.
+func readVersion(text []byte) (float32, error) {
+	var header struct {
+		Version float32 `json:"version" zpl:"version"`
+	}
+	if isJSON(text) {
+		if err := json.Unmarshal(text, &header); err != nil {
+			return 0, jsonError(text, err)
+		}
+		return header.Version, nil
+	}
+	root, err := scanZPL(text)
+	if err != nil {
+		return 0, err
+	}
+	for _, node := range root.children {
+		if node.name == "version" {
+			if err = checkZPL(node, reflect.TypeOf(header.Version), ""); err != nil {
+				return 0, err
+			}
+			version, _ := strconv.ParseFloat(node.value, 32)
+			return float32(version), nil
+		}
+	}
+	return 0, nil
+}
+
+// parseZdcf decodes configuration text of any supported version and format,
+// read from the named file, if any, converting ZDCF 0.x to 1.x as the
+// configuration of the named app.  Keys that are not part of that version of
+// ZDCF are returned as problems rather than errors so that they can be
+// reported along with any others.
+func parseZdcf(appName, name string, text []byte) (*Config, ValidationErrors, error) {
+	text, converted, err := convert(name, text)
+	if err != nil {
+		return nil, nil, err
+	}
+	conf, problems, err := decodeZdcf(appName, text)
+	if converted {
+		withoutPosition(problems)
+		if err != nil {
+			err = withoutPosition(err)
+		}
+	}
+	return conf, problems, err
+}
+
+// decodeZdcf decodes configuration text in JSON or ZPL as parseZdcf does.
+func decodeZdcf(appName string, text []byte) (*Config, ValidationErrors, error) {
+	version, err := readVersion(text)
+	if err != nil {
+		return nil, nil, err
+	}
+	if version < 1 {
+		conf0, err := unmarshalZdcf0(text)
+		if err != nil {
+			return nil, nil, err
+		}
+		return conf0.config(appName), unknownKeys(text, reflect.TypeOf(conf0)), nil
+	}
+	conf, err := unmarshalZdcf1(text)
+	if err != nil {
+		return nil, nil, err
+	}
+	return conf, unknownKeys(text, reflect.TypeOf(conf)), nil
+}
+
+// versionError describes an unsupported version, locating it in text.
+func versionError(text []byte, version float32) error {
+	err := &ParseError{
+		Key: "version",
+		Err: fmt.Errorf("unsupported ZDCF version: %f", version),
+	}
+	if isJSON(text) {
+		if _, start, ok := walkJSON(text, func(path string, token json.Token, start, end int64) bool {
+			return path == "version"
+		}); ok {
+			err.Line, err.Column = position(text, start)
+		}
+	} else if root, _ := scanZPL(text); root != nil {
+		for _, node := range root.children {
+			if node.name == "version" {
+				err.Line, err.Column = node.line, node.valueColumn
+			}
+		}
+	}
+	return err
+}
+
+// position converts a byte offset in text to a line and column.
+func position(text []byte, offset int64) (line, column int) {
+	if offset > int64(len(text)) {
+		offset = int64(len(text))
+	}
+	before := text[:offset]
+	lineStart := bytes.LastIndexByte(before, '\n') + 1
+	return bytes.Count(before, []byte{'\n'}) + 1,
+		utf8.RuneCount(before[lineStart:]) + 1
+}
+
+// jsonError converts an error from encoding/json into a ParseError.
+func jsonError(text []byte, err error) error {
+	var offset int64
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		offset = e.Offset
+	case *json.UnmarshalTypeError:
+		offset = e.Offset
+		err = fmt.Errorf("cannot use %s as %s", e.Value, e.Type)
+	default:
+		return &ParseError{Err: err}
+	}
+	key, start, found := walkJSON(text, func(path string, token json.Token, start, end int64) bool {
+		return end >= offset
+	})
+	if !found && offset > 0 {
+		// the offset of a syntax error is just past the offending byte
+		start = offset - 1
+	}
+	line, column := position(text, start)
+	return &ParseError{Line: line, Column: column, Key: key, Err: err}
+}
+
+// checkJSON checks that each value in the JSON text can be decoded into the
+// type t, reporting the position and path of the first that cannot.  Keys
+// that do not correspond to any field are ignored.
+//
+// This is done before decoding because encoding/json cannot say where an
+// error occurred within a value that has its own UnmarshalJSON method.
+func checkJSON(text []byte, t reflect.Type) error {
+	var problem error
+	walkJSON(text, func(path string, token json.Token, start, end int64) bool {
+		var names []string
+		if len(path) > 0 {
+			names = strings.Split(path, "/")
+		}
+		target, unknown := keyType(t, names, "json")
+		if unknown >= 0 || token == nil {
+			return false
+		}
+		for target.Kind() == reflect.Ptr {
+			target = target.Elem()
+		}
+		var (
+			kind = target.Kind()
+			ok   bool
+			what string
+		)
+		switch value := token.(type) {
+		case json.Delim:
+			if value == '{' {
+				what, ok = "object", kind == reflect.Struct || kind == reflect.Map
+			} else {
+				what, ok = "array", kind == reflect.Slice
+			}
+		case string:
+			// a single string may be given in place of a list of them
+			what, ok = "string", kind == reflect.String ||
+				kind == reflect.Slice && target.Elem().Kind() == reflect.String
+		case float64:
+			what = "number"
+			switch kind {
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+				reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+				ok = value == math.Trunc(value)
+			case reflect.Float32, reflect.Float64:
+				ok = true
+			}
+		case bool:
+			what, ok = "bool", kind == reflect.Bool
+		}
+		if ok || kind == reflect.Interface {
+			return false
+		}
+		line, column := position(text, start)
+		problem = &ParseError{
+			Line:   line,
+			Column: column,
+			Key:    path,
+			Err:    fmt.Errorf("cannot use %s as %s", what, kind),
+		}
+		return true
+	})
+	return problem
+}
+
+// walkJSON calls visit with the path, the first token (see json.Decoder.Token)
+// and the start and end offsets of each value in text, in order, until visit
+// returns true.  It returns the path and starting offset of that value or, if
+// there is none, of where it stopped.
+func walkJSON(text []byte, visit func(path string, token json.Token, start, end int64) bool) (string, int64, bool) {
+	type frame struct {
+		object  bool
+		haveKey bool
+		key     string
+		index   int
+	}
+	var (
+		dec    = json.NewDecoder(bytes.NewReader(text))
+		frames []frame
+	)
+	path := func() string {
+		var names []string
+		for _, f := range frames {
+			if !f.object {
+				names = append(names, strconv.Itoa(f.index))
+			} else if f.haveKey {
+				names = append(names, f.key)
+			}
+		}
+		return strings.Join(names, "/")
+	}
+	advance := func() {
+		if n := len(frames); n > 0 {
+			if frames[n-1].object {
+				frames[n-1].haveKey = false
+			} else {
+				frames[n-1].index++
+			}
+		}
+	}
+	for {
+		start := dec.InputOffset()
+		token, err := dec.Token()
+		for start < int64(len(text)) && strings.IndexByte(" \t\r\n,:", text[start]) >= 0 {
+			start++
+		}
+		if err != nil {
+			return path(), start, false
+		}
+		end := dec.InputOffset()
+		n := len(frames)
+		if token == json.Delim('}') || token == json.Delim(']') {
+			frames = frames[:n-1]
+			advance()
+			continue
+		}
+		if n > 0 && frames[n-1].object && !frames[n-1].haveKey {
+			frames[n-1].key, _ = token.(string)
+			frames[n-1].haveKey = true
+			continue
+		}
+		if visit(path(), token, start, end) {
+			return path(), start, true
+		}
+		switch token {
+		case json.Delim('{'):
+			frames = append(frames, frame{object: true})
+		case json.Delim('['):
+			frames = append(frames, frame{})
+		default:
+			advance()
+		}
+	}
+}
+
+// A zplNode is a name, with either a value or some children, read from ZPL.
+type zplNode struct {
+	name        string
+	value       string
+	hasValue    bool
+	line        int
+	column      int
+	valueColumn int
+	children    []*zplNode
+}
+
+func isZPLNameChar(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		strings.IndexByte("$-_@.&+/", c) >= 0
+}
+
+// scanZPL reads the structure of ZPL text (see http://rfc.zeromq.org/spec:4),
+// reporting the position of any syntax error.
+func scanZPL(text []byte) (*zplNode, error) {
+	var (
+		root  = &zplNode{}
+		stack = []*zplNode{root}
+	)
+	for i, line := range strings.Split(string(text), "\n") {
+		var (
+			lineNo  = i + 1
+			trimmed = strings.TrimLeft(strings.TrimRight(line, "\r"), " ")
+			indent  = len(line) - len(strings.TrimLeft(line, " "))
+			fail    = func(column int, message string) error {
+				return &ParseError{Line: lineNo, Column: column, Err: errors.New(message)}
+			}
+		)
+		if len(strings.TrimSpace(trimmed)) == 0 || trimmed[0] == '#' {
+			continue
+		}
+		if trimmed[0] == '\t' {
+			return nil, fail(indent+1, "indentation must be spaces, not tabs")
+		}
+		if indent%4 != 0 {
+			return nil, fail(indent+1, "indentation must be a multiple of 4 spaces")
+		}
+		depth := indent / 4
+		if depth >= len(stack) {
+			return nil, fail(indent+1, "unexpected indentation")
+		}
+		stack = stack[:depth+1]
+		end := 0
+		for end < len(trimmed) && isZPLNameChar(trimmed[end]) {
+			end++
+		}
+		if end == 0 {
+			return nil, fail(indent+1, fmt.Sprintf("unexpected %q where a name was expected", trimmed[0]))
+		}
+		node := &zplNode{name: trimmed[:end], line: lineNo, column: indent + 1}
+		rest := strings.TrimLeft(trimmed[end:], " ")
+		column := len(line) - len(rest) + 1
+		switch {
+		case len(rest) == 0 || rest[0] == '#':
+		case rest[0] == '=':
+			rest = strings.TrimLeft(rest[1:], " ")
+			column = len(line) - len(rest) + 1
+			node.hasValue, node.valueColumn = true, column
+			if len(rest) > 0 && (rest[0] == '"' || rest[0] == '\'') {
+				closing := strings.IndexByte(rest[1:], rest[0])
+				if closing < 0 {
+					return nil, fail(column, "unterminated quoted value")
+				}
+				node.value = rest[1 : closing+1]
+				after := strings.TrimLeft(rest[closing+2:], " ")
+				if len(after) > 0 && after[0] != '#' {
+					return nil, fail(len(line)-len(after)+1, "unexpected text after quoted value")
+				}
+			} else {
+				if comment := strings.Index(rest, " #"); comment >= 0 {
+					rest = rest[:comment]
+				}
+				node.value = strings.TrimSpace(rest)
+			}
+		default:
+			return nil, fail(column, fmt.Sprintf("unexpected %q after name", rest[0]))
+		}
+		parent := stack[depth]
+		parent.children = append(parent.children, node)
+		if !node.hasValue {
+			stack = append(stack, node)
+		}
+	}
+	return root, nil
+}
+
+// keyType returns the type that the value at path is decoded into from text
+// that is decoded into the type t, reading struct fields' names from the
+// given tag.  The index of the first name in path that does not correspond to
+// anything is also returned, or -1 if there is none.
+func keyType(t reflect.Type, path []string, tag string) (reflect.Type, int) {
+	for i, name := range path {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		switch t.Kind() {
+		case reflect.Struct:
+			field, ok := structField(t, name, tag)
+			if !ok {
+				return nil, i
+			}
+			t = field
+		case reflect.Map, reflect.Slice:
+			t = t.Elem()
+		default:
+			return t, -1
+		}
+	}
+	return t, -1
+}
+
+// structField returns the type of the struct field named by the tag, or else
+// of the values of a catch-all map field tagged zpl:"*".
+func structField(t reflect.Type, name, tag string) (reflect.Type, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		if field := t.Field(i); strings.Split(field.Tag.Get(tag), ",")[0] == name {
+			return field.Type, true
+		}
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if field := t.Field(i); field.Tag.Get("zpl") == "*" {
+			return field.Type.Elem(), true
+		}
+	}
+	return nil, false
+}
+
+// checkZPL checks that each value in the ZPL tree can be decoded into the
+// type t, reporting the position and path of any that cannot.  Names that do
+// not correspond to any field are ignored.
+func checkZPL(node *zplNode, t reflect.Type, path string) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if len(path) > 0 {
+		path += "/"
+	}
+	path += node.name
+	fail := func(column int, message string) error {
+		return &ParseError{Line: node.line, Column: column, Key: path, Err: errors.New(message)}
+	}
+	switch t.Kind() {
+	case reflect.Struct, reflect.Map:
+		if node.hasValue {
+			return fail(node.valueColumn, "expected a section, not a value")
+		}
+		for _, child := range node.children {
+			var childType reflect.Type
+			if t.Kind() == reflect.Map {
+				childType = t.Elem()
+			} else if field, ok := structField(t, child.name, "zpl"); !ok {
+				continue
+			} else {
+				childType = field
+			}
+			if err := checkZPL(child, childType, path); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Interface:
+		return nil
+	case reflect.Slice:
+		t = t.Elem()
+	}
+	if !node.hasValue {
+		return fail(node.column, "expected a value, not a section")
+	}
+	var err error
+	switch t.Kind() {
+	case reflect.Bool:
+		_, err = strconv.ParseBool(node.value)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		_, err = strconv.ParseInt(node.value, 10, t.Bits())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		_, err = strconv.ParseUint(node.value, 10, t.Bits())
+	case reflect.Float32, reflect.Float64:
+		_, err = strconv.ParseFloat(node.value, t.Bits())
+	}
+	if err != nil {
+		return fail(node.valueColumn, fmt.Sprintf("cannot use %q as %s", node.value, t.Kind()))
+	}
+	return nil
+}
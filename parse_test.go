@@ -0,0 +1,106 @@
+// Copyright 2013 Joshua Tacoma. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zdcf
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseZdcf_Errors(t *testing.T) {
+	for _, test := range []struct {
+		text     string
+		line     int
+		column   int
+		key      string
+		contains string
+	}{
+		{`{
+    "version": 1.0,
+    "apps": {
+        "listener": {
+            "context": {"iothreads": "two"}
+        }
+    }
+}`, 5, 38, "apps/listener/context/iothreads", "cannot use string as int"},
+		{`{
+    "version": 1.0,
+    "apps": {
+        "listener": {,
+    }
+}`, 4, 22, "apps/listener", "invalid character ','"},
+		{`{"version": 3.0}`, 1, 13, "version", "unsupported ZDCF version"},
+		{`
+version = 1.0
+apps
+    listener
+        devices
+            main
+                sockets
+                    frontend
+                        option
+                            hwm = lots`, 10, 35, "apps/listener/devices/main/sockets/frontend/option/hwm", `cannot use "lots" as int`},
+		{`
+version = 1.0
+apps
+    listener
+      devices`, 5, 7, "", "multiple of 4 spaces"},
+		{`
+version = 1.0
+apps
+    listener = "echo`, 4, 16, "", "unterminated"},
+		{`
+version = 1.0
+apps
+    listener
+        devices = none`, 5, 19, "apps/listener/devices", "expected a section"},
+		{`
+version = 0.1
+main
+    type = zmq_queue
+    frontend
+        type
+            ROUTER`, 6, 9, "main/frontend/type", "expected a value"},
+		{`
+version = one`, 2, 11, "version", `cannot use "one" as float32`},
+	} {
+		_, _, err := parseZdcf("listener", "", []byte(test.text))
+		parseErr, ok := err.(*ParseError)
+		if !ok {
+			t.Errorf("%s: err = %v", test.text, err)
+			continue
+		}
+		if parseErr.Line != test.line || parseErr.Column != test.column || parseErr.Key != test.key {
+			t.Errorf("%s: at %d:%d %q, expected %d:%d %q: %s", test.text,
+				parseErr.Line, parseErr.Column, parseErr.Key,
+				test.line, test.column, test.key, err)
+		}
+		if !strings.Contains(err.Error(), test.contains) {
+			t.Errorf("%s: err = %s", test.text, err)
+		}
+		if strings.Contains(err.Error(), "ZPL") {
+			t.Errorf("%s: err = %s", test.text, err)
+		}
+	}
+}
+
+func TestParseError_Error(t *testing.T) {
+	err := &ParseError{
+		Source: "app.zdcf",
+		Line:   3,
+		Column: 5,
+		Key:    "apps/listener",
+		Err:    errors.New("unexpected indentation"),
+	}
+	expected := "app.zdcf:3:5: apps/listener: unexpected indentation"
+	if err.Error() != expected {
+		t.Errorf("err = %q, expected %q", err.Error(), expected)
+	}
+	err = &ParseError{Err: err.Err}
+	if err.Error() != err.Err.Error() {
+		t.Errorf("err = %q", err.Error())
+	}
+}
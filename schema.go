@@ -0,0 +1,487 @@
+// Copyright 2013 Joshua Tacoma. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zdcf
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A jsonSchema is a JSON Schema (draft 7), or the part of one that describes
+// a value within a document.  Only the keywords that the schemas generated
+// from the configuration types use are included.
+type jsonSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Ref                  string                 `json:"$ref,omitempty"`
+	Type                 interface{}            `json:"type,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	AdditionalProperties interface{}            `json:"additionalProperties,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	Enum                 []string               `json:"enum,omitempty"`
+	Pattern              string                 `json:"pattern,omitempty"`
+	Minimum              *float64               `json:"minimum,omitempty"`
+	ExclusiveMaximum     *float64               `json:"exclusiveMaximum,omitempty"`
+	AnyOf                []*jsonSchema          `json:"anyOf,omitempty"`
+	Definitions          map[string]*jsonSchema `json:"definitions,omitempty"`
+}
+
+// JSONSchema returns a JSON Schema for ZDCF documents of the given major
+// version, 0 or 1.  It is generated from the types into which documents are
+// decoded, so it describes every key that NewApp accepts, along with the
+// checks on values that NewApp makes without regard to other sources, such as
+// the socket types that are known.  Strings that refer to variables are
+// accepted wherever a string is.
+func JSONSchema(major int) ([]byte, error) {
+	s, err := schemaFor(major)
+	if err != nil {
+		return nil, err
+	}
+	return marshalJSON(s)
+}
+
+var schemas = map[int]*jsonSchema{
+	0: generateSchema(reflect.TypeOf(zdcf0{}), "ZDCF 0.x"),
+	1: generateSchema(reflect.TypeOf(Config{}), "ZDCF 1.x"),
+}
+
+func schemaFor(major int) (*jsonSchema, error) {
+	s, ok := schemas[major]
+	if !ok {
+		return nil, fmt.Errorf("no schema for ZDCF %d.x", major)
+	}
+	return s, nil
+}
+
+// generateSchema generates a schema for documents decoded into the struct
+// type t.  Each struct type is described once, as a definition that the
+// others refer to.
+func generateSchema(t reflect.Type, title string) *jsonSchema {
+	defs := map[string]*jsonSchema{}
+	s := structSchema(t, defs)
+	s.Schema, s.Title, s.Definitions = "http://json-schema.org/draft-07/schema#", title, defs
+	return s
+}
+
+func typeSchema(t reflect.Type, defs map[string]*jsonSchema) *jsonSchema {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return typeSchema(t.Elem(), defs)
+	case reflect.Struct:
+		name := strings.ToLower(strings.TrimSuffix(t.Name(), "Config"))
+		if _, ok := defs[name]; !ok {
+			defs[name] = nil
+			defs[name] = structSchema(t, defs)
+		}
+		return &jsonSchema{Ref: "#/definitions/" + name}
+	case reflect.Map:
+		elem := typeSchema(t.Elem(), defs)
+		if t.Elem().Kind() == reflect.Ptr {
+			elem = &jsonSchema{AnyOf: []*jsonSchema{elem, {Type: "null"}}}
+		}
+		return &jsonSchema{Type: "object", AdditionalProperties: elem}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.String {
+			return &jsonSchema{Type: []string{"string", "array"}, Items: typeSchema(t.Elem(), defs)}
+		}
+		return &jsonSchema{Type: "array", Items: typeSchema(t.Elem(), defs)}
+	case reflect.String:
+		return &jsonSchema{Type: "string"}
+	case reflect.Bool:
+		return &jsonSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &jsonSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &jsonSchema{Type: "number"}
+	case reflect.Interface:
+		return &jsonSchema{}
+	}
+	panic("zdcf: no schema for " + t.String())
+}
+
+// structSchema describes a struct type as an object with a property for each
+// field.  A field tagged zpl:"*" collects every other key, as it does in
+// decoding.
+func structSchema(t reflect.Type, defs map[string]*jsonSchema) *jsonSchema {
+	s := &jsonSchema{
+		Type:                 "object",
+		Properties:           map[string]*jsonSchema{},
+		AdditionalProperties: false,
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Tag.Get("zpl") == "*" {
+			s.AdditionalProperties = typeSchema(field.Type, defs).AdditionalProperties
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if len(name) == 0 || name == "-" {
+			continue
+		}
+		s.Properties[name] = typeSchema(field.Type, defs)
+		if constrain, ok := schemaConstraints[t.Name()+"."+name]; ok {
+			constrain(s, s.Properties[name])
+		}
+	}
+	return s
+}
+
+const (
+	variablePattern = `\$\{`
+	endpointPattern = `^(tcp://.+:(\*|[1-9][0-9]{0,4})|e?pgm://[^;]+;.+:[1-9][0-9]{0,4}|(ipc|inproc)://.+)$`
+)
+
+// schemaConstraints adds to the schemas of properties, named by their struct
+// type and key, the checks that validateApp makes of them.
+var schemaConstraints = map[string]func(object, property *jsonSchema){
+	"Config.version": func(object, property *jsonSchema) {
+		object.Required = []string{"version"}
+		property.Minimum, property.ExclusiveMaximum = schemaNumber(1), schemaNumber(2)
+	},
+	"zdcf0.version": func(object, property *jsonSchema) {
+		property.Minimum, property.ExclusiveMaximum = schemaNumber(0), schemaNumber(1)
+	},
+	"ContextConfig.iothreads": minimumConstraint(0),
+	"ContextConfig.linger":    minimumConstraint(-1),
+	"RestartConfig.policy": func(object, property *jsonSchema) {
+		property.AnyOf = []*jsonSchema{
+			{Enum: []string{restartNever, restartOnFailure, restartAlways}},
+			{Pattern: variablePattern},
+		}
+	},
+	"RestartConfig.backoff":      minimumConstraint(0),
+	"RestartConfig.max_backoff":  minimumConstraint(0),
+	"RestartConfig.max_restarts": minimumConstraint(0),
+	"SocketConfig.type": func(object, property *jsonSchema) {
+		property.AnyOf = []*jsonSchema{
+			{Enum: sortedKeys(socketTypes)},
+			{Pattern: variablePattern},
+		}
+	},
+	"SocketConfig.bind":     endpointConstraint,
+	"SocketConfig.bind+":    endpointConstraint,
+	"SocketConfig.connect":  endpointConstraint,
+	"SocketConfig.connect+": endpointConstraint,
+}
+
+func schemaNumber(n float64) *float64 { return &n }
+
+func minimumConstraint(n float64) func(object, property *jsonSchema) {
+	return func(object, property *jsonSchema) {
+		property.Minimum = schemaNumber(n)
+	}
+}
+
+// endpointConstraint checks each endpoint of a list that may also be given as
+// a single string, which is how ZPL gives a lone bind or connect.
+func endpointConstraint(object, property *jsonSchema) {
+	endpoint := []*jsonSchema{
+		{Pattern: endpointPattern},
+		{Pattern: variablePattern},
+	}
+	property.AnyOf = []*jsonSchema{
+		{Type: "string", AnyOf: endpoint},
+		{Type: "array", Items: &jsonSchema{Type: "string", AnyOf: endpoint}},
+	}
+	property.Items = nil
+}
+
+// A SchemaError is a violation of the JSON Schema for ZDCF.
+type SchemaError struct {
+	Source  string // the name of the file, if known
+	Pointer string // a JSON pointer (RFC 6901) to the offending value, e.g. /apps/echo
+	Err     error
+}
+
+func (e *SchemaError) Error() string {
+	var message string
+	if len(e.Source) > 0 {
+		message = e.Source + ": "
+	}
+	if len(e.Pointer) > 0 {
+		message += e.Pointer + ": "
+	}
+	return message + e.Err.Error()
+}
+
+// SchemaErrors lists every violation of the JSON Schema for ZDCF found in a
+// set of documents.
+type SchemaErrors []*SchemaError
+
+func (e SchemaErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// ValidateSchema checks each document in the sources, in any format that can
+// be read, against the JSON Schema for its version of ZDCF, as returned by
+// JSONSchema.  Unlike Validate, it checks documents one at a time, without
+// merging, including or interpolating anything.  Every violation is returned
+// at once as SchemaErrors.
+func ValidateSchema(sources ...interface{}) error {
+	var violations SchemaErrors
+	for _, source := range sources {
+		texts, ok, err := readSource(source)
+		if !ok {
+			return errors.New("unsupported configuration source.")
+		} else if err != nil {
+			return err
+		}
+		for _, text := range texts {
+			found, err := validateSchema(text.name, text.text)
+			if err != nil {
+				return sourceError(text.name, err)
+			}
+			for _, violation := range found {
+				violation.Source = text.name
+			}
+			violations = append(violations, found...)
+		}
+	}
+	if len(violations) > 0 {
+		return violations
+	}
+	return nil
+}
+
+// validateSchema checks one document, read from the named file.  Values in
+// ZPL, which are all strings, are taken to be of whatever type the schema
+// expects if they can be read as one.
+func validateSchema(name string, text []byte) (SchemaErrors, error) {
+	var (
+		doc     interface{}
+		untyped bool
+	)
+	if f := findFormat(name, text); f.native && !isJSON(text) {
+		root, err := scanZPL(text)
+		if err != nil {
+			return nil, err
+		}
+		doc, untyped = zplValues(root), true
+	} else {
+		converted, _, err := convert(name, text)
+		if err != nil {
+			return nil, err
+		}
+		decoder := json.NewDecoder(bytes.NewReader(converted))
+		decoder.UseNumber()
+		if err = decoder.Decode(&doc); err != nil {
+			return nil, jsonError(converted, err)
+		}
+	}
+	var major int
+	if m, ok := doc.(map[string]interface{}); ok {
+		if version, ok := schemaFloat(m["version"], untyped); ok && version >= 1 {
+			major = 1
+		}
+	}
+	s, err := schemaFor(major)
+	if err != nil {
+		return nil, err
+	}
+	v := schemaValidator{root: s, untyped: untyped}
+	return v.validate(s, doc, ""), nil
+}
+
+// zplValues returns the values in a ZPL section as encoding/json would
+// decode them from JSON, except that every value is a string.  A name that is
+// repeated holds a list.
+func zplValues(node *zplNode) interface{} {
+	m := map[string]interface{}{}
+	for _, child := range node.children {
+		var v interface{} = child.value
+		if !child.hasValue {
+			v = zplValues(child)
+		}
+		switch prev := m[child.name].(type) {
+		case nil:
+			m[child.name] = v
+		case []interface{}:
+			m[child.name] = append(prev, v)
+		default:
+			m[child.name] = []interface{}{prev, v}
+		}
+	}
+	return m
+}
+
+type schemaValidator struct {
+	root    *jsonSchema
+	untyped bool
+}
+
+var schemaPatterns = map[string]*regexp.Regexp{
+	variablePattern: regexp.MustCompile(variablePattern),
+	endpointPattern: regexp.MustCompile(endpointPattern),
+}
+
+func (sv *schemaValidator) validate(s *jsonSchema, v interface{}, pointer string) (violations SchemaErrors) {
+	report := func(pointer, format string, args ...interface{}) {
+		violations = append(violations, &SchemaError{Pointer: pointer, Err: fmt.Errorf(format, args...)})
+	}
+	if len(s.Ref) > 0 {
+		return sv.validate(sv.root.Definitions[strings.TrimPrefix(s.Ref, "#/definitions/")], v, pointer)
+	}
+	if s.Type != nil {
+		types, ok := s.Type.([]string)
+		if !ok {
+			types = []string{s.Type.(string)}
+		}
+		if actual, ok := sv.typeOf(v, types); !ok {
+			report(pointer, "expected %s, not %s.", strings.Join(types, " or "), actual)
+			return violations
+		}
+	}
+	if len(s.AnyOf) > 0 {
+		// if every alternative fails, report the first whose type fits
+		var first SchemaErrors
+		fits := false
+		for _, alternative := range s.AnyOf {
+			found := sv.validate(alternative, v, pointer)
+			if len(found) == 0 {
+				first = nil
+				break
+			}
+			if first == nil || !fits && sv.fits(alternative, v) {
+				first, fits = found, sv.fits(alternative, v)
+			}
+		}
+		violations = append(violations, first...)
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, key := range s.Required {
+			if _, ok := v[key]; !ok {
+				report(pointer, "missing %s.", key)
+			}
+		}
+		for _, key := range sortedKeys(v) {
+			keyPointer := pointer + "/" + strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+			if property, ok := s.Properties[key]; ok {
+				violations = append(violations, sv.validate(property, v[key], keyPointer)...)
+			} else if additional, ok := s.AdditionalProperties.(*jsonSchema); ok {
+				violations = append(violations, sv.validate(additional, v[key], keyPointer)...)
+			} else if s.AdditionalProperties == false {
+				report(keyPointer, "unknown key.")
+			}
+		}
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range v {
+				violations = append(violations, sv.validate(s.Items, item, pointer+"/"+strconv.Itoa(i))...)
+			}
+		}
+	case string:
+		if len(s.Enum) > 0 {
+			found := false
+			for _, value := range s.Enum {
+				found = found || value == v
+			}
+			if !found {
+				report(pointer, "%q is not one of %s.", v, strings.Join(s.Enum, ", "))
+			}
+		}
+		if len(s.Pattern) > 0 {
+			if !schemaPatterns[s.Pattern].MatchString(v) {
+				report(pointer, "%q does not match %s.", v, s.Pattern)
+			}
+		}
+	}
+	if n, ok := schemaFloat(v, sv.untyped); ok {
+		if s.Minimum != nil && n < *s.Minimum {
+			report(pointer, "must be at least %v.", *s.Minimum)
+		}
+		if s.ExclusiveMaximum != nil && n >= *s.ExclusiveMaximum {
+			report(pointer, "must be less than %v.", *s.ExclusiveMaximum)
+		}
+	}
+	return violations
+}
+
+// fits reports whether v is of the type that s requires, if any.
+func (sv *schemaValidator) fits(s *jsonSchema, v interface{}) bool {
+	if s.Type == nil {
+		return true
+	}
+	types, ok := s.Type.([]string)
+	if !ok {
+		types = []string{s.Type.(string)}
+	}
+	_, ok = sv.typeOf(v, types)
+	return ok
+}
+
+// typeOf returns the JSON type of v, choosing from types if v is a string in
+// untyped ZPL that could be read as one of them, and reports whether it is one
+// of types.
+func (sv *schemaValidator) typeOf(v interface{}, types []string) (string, bool) {
+	var actual []string
+	switch v := v.(type) {
+	case nil:
+		actual = []string{"null"}
+	case bool:
+		actual = []string{"boolean"}
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			actual = []string{"integer", "number"}
+		} else {
+			actual = []string{"number"}
+		}
+	case string:
+		actual = []string{"string"}
+		if sv.untyped {
+			if _, err := strconv.ParseInt(v, 10, 64); err == nil {
+				actual = append(actual, "integer")
+			}
+			if _, err := strconv.ParseFloat(v, 64); err == nil {
+				actual = append(actual, "number")
+			}
+			if _, err := strconv.ParseBool(v); err == nil {
+				actual = append(actual, "boolean")
+			}
+		}
+	case []interface{}:
+		actual = []string{"array"}
+	case map[string]interface{}:
+		actual = []string{"object"}
+	}
+	for _, a := range actual {
+		for _, t := range types {
+			if a == t {
+				return a, true
+			}
+		}
+	}
+	return actual[0], false
+}
+
+// schemaFloat returns the value of a number, or of a string in untyped ZPL
+// that can be read as one.
+func schemaFloat(v interface{}, untyped bool) (float64, bool) {
+	var text string
+	switch v := v.(type) {
+	case json.Number:
+		text = v.String()
+	case string:
+		if !untyped {
+			return 0, false
+		}
+		text = v
+	default:
+		return 0, false
+	}
+	n, err := strconv.ParseFloat(text, 64)
+	return n, err == nil
+}
@@ -0,0 +1,221 @@
+// Copyright 2013 Joshua Tacoma. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zdcf
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestJSONSchema_Shipped(t *testing.T) {
+	for _, major := range []int{0, 1} {
+		text, err := JSONSchema(major)
+		if err != nil {
+			t.Fatalf("%d: %s", major, err)
+		}
+		name := fmt.Sprintf("schema/zdcf-%d.json", major)
+		shipped, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(shipped) != string(text) {
+			t.Errorf("%s is out of date; regenerate it with: zdcf schema -version %d > %s", name, major, name)
+		}
+	}
+	if _, err := JSONSchema(2); err == nil {
+		t.Errorf("JSONSchema(2) succeeded")
+	}
+}
+
+func TestValidateSchema(t *testing.T) {
+	for i, text := range []string{
+		`{
+			"version": 1.0,
+			"include": "base.json",
+			"apps": {
+				"listener": {
+					"context": {"iothreads": 1, "linger": -1, "verbose": true},
+					"templates": {
+						"queue": {"type": "zmq_queue", "restart": {"policy": "on-failure", "backoff": 100}}
+					},
+					"devices": {
+						"main": {
+							"extends": "queue",
+							"sockets": {
+								"frontend": {
+									"type": "ROUTER",
+									"option": {"hwm": 1000, "mcast_loop": false, "subscribe": ["a", "b"]},
+									"bind": ["tcp://eth0:5555", "inproc://main"],
+									"connect+": "epgm://eth0;239.192.1.1:5555"
+								},
+								"backend": {"type": "${BACKEND_TYPE}", "bind": "tcp://${HOST}"},
+								"old": null
+							}
+						},
+						"gone": null
+					}
+				}
+			}
+		}`,
+		`
+version = 1.0
+apps
+    listener
+        context
+            iothreads = 1
+            verbose = true
+        devices
+            main
+                type = zmq_queue
+                restart
+                    max_restarts = 3
+                sockets
+                    frontend
+                        type = SUB
+                        option
+                            hwm = 1000
+                            subscribe = a
+                            subscribe = b
+                        bind = tcp://eth0:5555
+                        bind = ipc:///tmp/main`,
+		`
+version = 0.1
+context
+    iothreads = 1
+main
+    type = zmq_queue
+    frontend
+        type = ROUTER
+        bind = tcp://eth0:5555`,
+		`{"main": {"type": "zmq_queue", "frontend": {"type": "ROUTER", "bind": "tcp://*:5555"}}}`,
+	} {
+		if err := ValidateSchema(text); err != nil {
+			t.Errorf("%d: %s", i, err)
+		}
+	}
+}
+
+func TestValidateSchema_Violations(t *testing.T) {
+	for _, test := range []struct {
+		text     string
+		expected []string
+	}{
+		{`{"apps": {"listener": {"devices": {}}}}`, []string{
+			"/apps/listener/devices: unknown key.",
+		}},
+		{`{"version": 2, "colour": "blue"}`, []string{
+			"/colour: unknown key.",
+			"/version: must be less than 2.",
+		}},
+		{`{
+			"version": 1.0,
+			"apps": {
+				"listener": {
+					"context": {"iothreads": -1, "linger": 1.5},
+					"devices": {
+						"main": {
+							"type": 1,
+							"restart": {"policy": "sometimes", "max_restarts": -1},
+							"sockets": {
+								"a/b": {"type": "ROUTER", "colour": "blue"},
+								"front~end": {"type": "ROUTE", "bind": ["tcp://eth0", "inproc://x"], "option": {"mcast_loop": "yes"}}
+							}
+						},
+						"other": "main"
+					}
+				}
+			}
+		}`, []string{
+			"/apps/listener/context/iothreads: must be at least 0.",
+			"/apps/listener/context/linger: expected integer, not number.",
+			"/apps/listener/devices/main/restart/max_restarts: must be at least 0.",
+			`/apps/listener/devices/main/restart/policy: "sometimes" is not one of never, on-failure, always.`,
+			"/apps/listener/devices/main/sockets/a~1b/colour: unknown key.",
+			`/apps/listener/devices/main/sockets/front~0end/bind/0: "tcp://eth0" does not match ` + endpointPattern + ".",
+			"/apps/listener/devices/main/sockets/front~0end/option/mcast_loop: expected boolean, not string.",
+			`/apps/listener/devices/main/sockets/front~0end/type: "ROUTE" is not one of DEALER, DOWNSTREAM, PAIR, PUB, PULL, PUSH, REP, REQ, ROUTER, SUB, UPSTREAM, XPUB, XREP, XREQ, XSUB.`,
+			"/apps/listener/devices/main/type: expected string, not integer.",
+			"/apps/listener/devices/other: expected object, not string.",
+		}},
+		{`
+version = 1.0
+apps
+    listener
+        context
+            iothreads = many
+        devices
+            main
+                restart
+                    backoff = -5`, []string{
+			"/apps/listener/context/iothreads: expected integer, not string.",
+			"/apps/listener/devices/main/restart/backoff: must be at least 0.",
+		}},
+		{`
+version = 0.1
+context
+    linger = -2
+main
+    frontend
+        bind = tcp://eth0:5555
+        bind = tcp://eth0`, []string{
+			"/context/linger: must be at least -1.",
+			`/main/frontend/bind/1: "tcp://eth0" does not match ` + endpointPattern + ".",
+		}},
+		{`{"version": 1.0, "apps": {"listener": {"devices": {"main": {"sockets": {"in": {"bind": "bogus"}}}}}}}`, []string{
+			`/apps/listener/devices/main/sockets/in/bind: "bogus" does not match ` + endpointPattern + ".",
+		}},
+		{`
+version = 1.0
+apps
+    listener
+        devices
+            main
+                sockets
+                    in
+                        connect = tcp://eth0`, []string{
+			`/apps/listener/devices/main/sockets/in/connect: "tcp://eth0" does not match ` + endpointPattern + ".",
+		}},
+	} {
+		err := ValidateSchema(test.text)
+		violations, ok := err.(SchemaErrors)
+		if !ok {
+			t.Errorf("%s: expected SchemaErrors, got %v", test.text, err)
+			continue
+		}
+		var messages []string
+		for _, violation := range violations {
+			messages = append(messages, violation.Error())
+		}
+		if fmt.Sprint(messages) != fmt.Sprint(test.expected) {
+			t.Errorf("%s:\ngot      %q\nexpected %q", test.text, messages, test.expected)
+		}
+	}
+}
+
+func TestValidateSchema_Sources(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"10-good.json": `{"version": 1.0}`,
+		"20-bad.yaml":  "version: 1.0\napps:\n  listener:\n    context:\n      iothreads: -1\n",
+		"30-bad.toml":  "version = 0.5\n[main]\ntype = \"zmq_queue\"\ncolour = {red = 1}\n",
+	})
+	err := ValidateSchema(Dir(dir))
+	expected := filepath.Join(dir, "20-bad.yaml") + ": /apps/listener/context/iothreads: must be at least 0.; " +
+		filepath.Join(dir, "30-bad.toml") + ": /main/colour/red: unknown key."
+	if err == nil || err.Error() != expected {
+		t.Errorf("got %v\nexpected %s", err, expected)
+	}
+	if err := ValidateSchema(File(filepath.Join(dir, "10-good.json"))); err != nil {
+		t.Errorf("10-good.json: %s", err)
+	}
+	for _, source := range []interface{}{`{"version": `, "version: [1", File(filepath.Join(dir, "no-such-file")), 1} {
+		if err := ValidateSchema(source); err == nil {
+			t.Errorf("%v: succeeded", source)
+		} else if _, ok := err.(SchemaErrors); ok {
+			t.Errorf("%v: %s", source, err)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2013 Joshua Tacoma. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zdcf
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+)
+
+// A File is a configuration source read from the named file.
+type File string
+
+// A Dir is a configuration source made of every file in the named directory
+// whose extension names a format that can be read, such as *.zdcf and *.json,
+// merged in lexical order.
+type Dir string
+
+// A Glob is a configuration source made of every file whose name matches the
+// pattern (see filepath.Match), merged in lexical order.
+type Glob string
+
+// A sourceText is configuration text along with the name of the file it was
+// read from, if any.
+type sourceText struct {
+	name string
+	text []byte
+}
+
+// readSource reads the configuration text from a source, which may produce
+// several pieces of text that are to be merged in order.  The bool result is
+// false if the source is not of a type that holds configuration text.
+func readSource(source interface{}) ([]sourceText, bool, error) {
+	switch s := source.(type) {
+	case string:
+		return []sourceText{{"", []byte(s)}}, true, nil
+	case []byte:
+		return []sourceText{{"", s}}, true, nil
+	case File:
+		text, err := readFiles(string(s))
+		return text, true, err
+	case Dir:
+		var names []string
+		for _, ext := range extensions() {
+			matches, err := filepath.Glob(filepath.Join(string(s), "*."+ext))
+			if err != nil {
+				return nil, true, err
+			}
+			names = append(names, matches...)
+		}
+		if len(names) == 0 {
+			return nil, true, fmt.Errorf("no configuration files in %s", s)
+		}
+		text, err := readFiles(names...)
+		return text, true, err
+	case Glob:
+		names, err := filepath.Glob(string(s))
+		if err != nil {
+			return nil, true, err
+		}
+		if len(names) == 0 {
+			return nil, true, fmt.Errorf("no configuration files match %s", s)
+		}
+		text, err := readFiles(names...)
+		return text, true, err
+	case io.Reader:
+		var name string
+		if named, ok := s.(interface {
+			Name() string
+		}); ok {
+			name = named.Name()
+		}
+		text, err := ioutil.ReadAll(s)
+		if err != nil {
+			return nil, true, sourceError(name, err)
+		}
+		return []sourceText{{name, text}}, true, nil
+	}
+	return nil, false, nil
+}
+
+// readFiles reads the named files in lexical order.
+func readFiles(names ...string) ([]sourceText, error) {
+	sort.Strings(names)
+	texts := make([]sourceText, len(names))
+	for i, name := range names {
+		text, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		texts[i] = sourceText{name, text}
+	}
+	return texts, nil
+}
+
+// sourceError adds the name of the file that caused an error, if there is one.
+func sourceError(name string, err error) error {
+	if len(name) == 0 {
+		return err
+	}
+	if parseErr, ok := err.(*ParseError); ok {
+		parseErr.Source = name
+		return parseErr
+	}
+	return fmt.Errorf("%s: %s", name, err)
+}
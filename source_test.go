@@ -0,0 +1,135 @@
+// Copyright 2013 Joshua Tacoma. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zdcf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "zdcf")
+	if err != nil {
+		t.Fatalf("failed to create directory: %s", err)
+	}
+	return writeFilesIn(t, dir, files)
+}
+
+func writeFilesIn(t *testing.T, dir string, files map[string]string) string {
+	for name, text := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(text), 0644); err != nil {
+			t.Fatalf("failed to write %s: %s", name, err)
+		}
+	}
+	return dir
+}
+
+func TestLoadApp_Sources(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"10-defaults.zdcf": `
+version = 1.0
+apps
+    listener
+        devices
+            main
+                type = zmq_queue
+                sockets
+                    frontend
+                        type = ROUTER
+                        bind = tcp://eth0:5555
+                    backend
+                        type = DEALER
+                        connect = tcp://eth0:5556`,
+		"20-local.json": `{
+			"version": 1.0,
+			"apps": {
+				"listener": {
+					"devices": {
+						"main": {
+							"sockets": {
+								"frontend": {
+									"bind": ["tcp://eth1:5555"]
+								}
+							}
+						}
+					}
+				}
+			}
+		}`,
+		"notes.txt": `not a configuration file`,
+	})
+	defer os.RemoveAll(dir)
+	for _, source := range []interface{}{
+		Dir(dir),
+		Glob(filepath.Join(dir, "*0-*")),
+		[]interface{}{File(filepath.Join(dir, "10-defaults.zdcf")), File(filepath.Join(dir, "20-local.json"))},
+	} {
+		sources, ok := source.([]interface{})
+		if !ok {
+			sources = []interface{}{source}
+		}
+		appConf, err := loadApp("listener", sources...)
+		if err != nil {
+			t.Errorf("%v: failed to load: %s", source, err)
+			continue
+		}
+		frontend := appConf.Devices["main"].Sockets["frontend"]
+		if frontend.Type != "ROUTER" || len(frontend.Bind) != 1 || frontend.Bind[0] != "tcp://eth1:5555" {
+			t.Errorf("%v: frontend = %v", source, frontend)
+		}
+	}
+	file, err := os.Open(filepath.Join(dir, "10-defaults.zdcf"))
+	if err != nil {
+		t.Fatalf("failed to open: %s", err)
+	}
+	defer file.Close()
+	appConf, err := loadApp("listener", file, strings.NewReader(`
+version = 1.0
+apps
+    listener
+        devices
+            main
+                type = zmq_streamer
+                sockets
+                    frontend
+                        type = PULL
+                    backend
+                        type = PUSH`))
+	if err != nil {
+		t.Fatalf("failed to load: %s", err)
+	}
+	if main := appConf.Devices["main"]; main.Type != "zmq_streamer" || len(main.Sockets["frontend"].Bind) != 1 {
+		t.Errorf("main = %v", main)
+	}
+}
+
+func TestLoadApp_SourceErrors(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"10-good.zdcf": `
+version = 1.0
+apps
+    listener`,
+		"20-bad.zdcf": `{"version": 1.0, "apps": `,
+	})
+	defer os.RemoveAll(dir)
+	bad := filepath.Join(dir, "20-bad.zdcf")
+	for source, expected := range map[interface{}]string{
+		Dir(dir):                                bad + ":1:",
+		File(bad):                               bad + ":1:",
+		File(filepath.Join(dir, "missing")):     "missing",
+		Glob(filepath.Join(dir, "*.json")):      "no configuration files match",
+		Dir(filepath.Join(dir, "10-good.zdcf")): "no configuration files in",
+	} {
+		_, err := loadApp("listener", source)
+		if err == nil {
+			t.Errorf("%v: loaded without error", source)
+		} else if !strings.Contains(err.Error(), expected) {
+			t.Errorf("%v: err = %s", source, err)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright 2013 Joshua Tacoma. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zdcf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// The TOML format is read by github.com/BurntSushi/toml.
+func init() {
+	RegisterFormat("toml", sniffTOML, decodeTOML, encodeTOML)
+}
+
+// sniffTOML reports whether text looks like TOML, which is when any of its
+// lines, comments aside, starts with a table header, or when it is TOML that
+// ZPL would read differently: text that is not ZPL at all, or that makes a
+// table with a dotted key, which ZPL would read as a single name.  TOML that
+// is also ZPL with the same meaning, such as version = 1.0, is read as ZPL.
+func sniffTOML(text []byte) bool {
+	if isJSON(text) {
+		return false
+	}
+	for _, line := range strings.Split(string(text), "\n") {
+		if line = strings.TrimSpace(line); strings.HasPrefix(line, "[") {
+			return true
+		}
+	}
+	var v map[string]interface{}
+	if _, err := toml.Decode(string(text), &v); err != nil {
+		return false
+	}
+	if _, err := scanZPL(text); err != nil {
+		return true
+	}
+	for _, value := range v {
+		if _, ok := value.(map[string]interface{}); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeTOML(text []byte) (interface{}, error) {
+	var v map[string]interface{}
+	if _, err := toml.Decode(string(text), &v); err != nil {
+		if parseErr, ok := err.(toml.ParseError); ok {
+			return nil, &ParseError{
+				Line:   parseErr.Position.Line,
+				Column: parseErr.Position.Col,
+				Err:    errors.New(strings.TrimSuffix(parseErr.Message, ".") + "."),
+			}
+		}
+		return nil, err
+	}
+	if v == nil {
+		v = map[string]interface{}{}
+	}
+	return v, nil
+}
+
+// encodeTOML writes a configuration as TOML, in the same order and with the
+// same guarantee as marshalJSON.  Each table is written under its own header,
+// after the values of the table that holds it, except for tables that hold
+// nothing but other tables.  A nil device or socket is written as a table
+// containing delete = true, TOML having no equivalent of null.
+func encodeTOML(conf *Config) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeTOML(&buf, reflect.ValueOf(conf), nil); err != nil {
+		return nil, err
+	}
+	return bytes.TrimLeft(buf.Bytes(), "\n"), nil
+}
+
+// writeTOML writes the fields of a struct, or the entries of a map, as the
+// table at path.
+func writeTOML(buf *bytes.Buffer, v reflect.Value, path []string) error {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	type entry struct {
+		name  string
+		value reflect.Value
+	}
+	var entries []entry
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+			if len(name) > 0 && name != "-" {
+				entries = append(entries, entry{name, v.Field(i)})
+			}
+		}
+	case reflect.Map:
+		for _, name := range sortedKeys(v.Interface()) {
+			entries = append(entries, entry{name, v.MapIndex(reflect.ValueOf(name).Convert(v.Type().Key()))})
+		}
+	default:
+		return fmt.Errorf("cannot write %s as a TOML table.", v.Type())
+	}
+	var (
+		values bytes.Buffer
+		tables []entry
+	)
+	for _, e := range entries {
+		value, pointed := e.value, false
+		if value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+			if value.IsNil() {
+				if v.Kind() == reflect.Map {
+					tables = append(tables, e)
+				}
+				continue
+			}
+			value, pointed = value.Elem(), true
+		}
+		switch {
+		case value.Kind() == reflect.Struct || value.Kind() == reflect.Map:
+			if value.Kind() == reflect.Struct || value.Len() > 0 {
+				tables = append(tables, entry{e.name, value})
+			}
+			continue
+		case value.Kind() == reflect.Slice && value.Len() == 0:
+			continue
+		case !pointed && value.IsZero():
+			continue
+		}
+		text, err := tomlValue(value)
+		if err != nil {
+			return fmt.Errorf("%s: %s", e.name, err)
+		}
+		values.WriteString(tomlKey(e.name) + " = " + text + "\n")
+	}
+	if len(path) > 0 && (values.Len() > 0 || len(tables) == 0) {
+		buf.WriteString("\n[" + strings.Join(path, ".") + "]\n")
+	}
+	buf.Write(values.Bytes())
+	for _, table := range tables {
+		tablePath := append(path[:len(path):len(path)], tomlKey(table.name))
+		if table.value.Kind() == reflect.Ptr {
+			buf.WriteString("\n[" + strings.Join(tablePath, ".") + "]\ndelete = true\n")
+			continue
+		}
+		if err := writeTOML(buf, table.value, tablePath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func tomlValue(v reflect.Value) (string, error) {
+	switch v.Kind() {
+	case reflect.String:
+		return tomlString(v.String()), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits()), nil
+	case reflect.Slice:
+		items := make([]string, v.Len())
+		for i := range items {
+			item, err := tomlValue(v.Index(i))
+			if err != nil {
+				return "", err
+			}
+			items[i] = item
+		}
+		return "[" + strings.Join(items, ", ") + "]", nil
+	}
+	return "", fmt.Errorf("cannot write %s as a TOML value.", v.Type())
+}
+
+var tomlBareKeyOnly = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func tomlKey(name string) string {
+	if tomlBareKeyOnly.MatchString(name) {
+		return name
+	}
+	return tomlString(name)
+}
+
+// tomlString quotes s as a basic string.
+func tomlString(s string) string {
+	var buf bytes.Buffer
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			buf.WriteByte('\\')
+			buf.WriteRune(r)
+		case r == '\n':
+			buf.WriteString(`\n`)
+		case r == '\t':
+			buf.WriteString(`\t`)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&buf, `\u%04X`, r)
+		default:
+			buf.WriteRune(r)
+		}
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
@@ -0,0 +1,95 @@
+// Copyright 2013 Joshua Tacoma. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zdcf
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeTOML(t *testing.T) {
+	type m = map[string]interface{}
+	type l = []interface{}
+	for _, test := range []struct {
+		text     string
+		expected interface{}
+	}{
+		{"", m{}},
+		{"version = 1.0 # a comment", m{"version": 1.0}},
+		{"a = 5_555\nb = -2\nc = 0x1F\nd = 1e3\ne = false", m{
+			"a": int64(5555), "b": int64(-2), "c": int64(31), "d": 1000.0, "e": false,
+		}},
+		{`s = "a\tb\u00e9"` + "\nr = 'C:\\path'", m{"s": "a\tb\u00e9", "r": `C:\path`}},
+		{"s = '''two\nlines'''\n[[t]]\nn = 1", m{"s": "two\nlines", "t": []map[string]interface{}{{"n": int64(1)}}}},
+		{`
+version = 1.0
+
+[apps.listener.context]
+iothreads = 1
+
+[apps.listener.devices.main]
+type = "zmq_queue"
+sockets.frontend.bind = ["tcp://eth0:5555", "tcp://eth1:5555"]
+
+[apps.listener.devices.main.sockets.backend]
+"bind+" = [
+    "ipc://a", # first
+    "ipc://b",
+]
+option = { hwm = 1000, subscribe = [] }
+`, m{
+			"version": 1.0,
+			"apps": m{"listener": m{
+				"context": m{"iothreads": int64(1)},
+				"devices": m{"main": m{
+					"type": "zmq_queue",
+					"sockets": m{
+						"frontend": m{"bind": l{"tcp://eth0:5555", "tcp://eth1:5555"}},
+						"backend": m{
+							"bind+":  l{"ipc://a", "ipc://b"},
+							"option": m{"hwm": int64(1000), "subscribe": l{}},
+						},
+					},
+				}},
+			}},
+		}},
+	} {
+		v, err := decodeTOML([]byte(test.text))
+		if err != nil {
+			t.Errorf("%q: %s", test.text, err)
+		} else if !reflect.DeepEqual(v, test.expected) {
+			t.Errorf("%q: got %#v", test.text, v)
+		}
+	}
+}
+
+func TestDecodeTOML_Errors(t *testing.T) {
+	for _, test := range []struct {
+		text     string
+		line     int
+		column   int
+		contains string
+	}{
+		{"a = 1\na = 2", 2, 7, "already been defined"},
+		{"[a]\n[a]", 2, 2, "already been defined"},
+		{"a = 1\n[a.b]", 2, 2, "already created"},
+		{"a = 1 2", 1, 6, "to end with a newline"},
+		{"a = \"open\nb = 1", 1, 10, "cannot contain newlines"},
+		{"a = nope", 1, 5, "expected value"},
+		{"a = [1 2]", 1, 8, "expected a comma"},
+		{"= 1", 1, 1, "key name appears blank"},
+	} {
+		_, err := decodeTOML([]byte(test.text))
+		if err == nil {
+			t.Errorf("%q: decoded without error", test.text)
+			continue
+		}
+		parseErr, ok := err.(*ParseError)
+		if !ok || parseErr.Line != test.line || parseErr.Column != test.column || !strings.Contains(err.Error(), test.contains) {
+			t.Errorf("%q: err = %s", test.text, err)
+		}
+	}
+}
@@ -0,0 +1,314 @@
+// Copyright 2013 Joshua Tacoma. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zdcf
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	zmq "github.com/alecthomas/gozmq"
+)
+
+// ValidationErrors lists every problem found in a configuration.
+type ValidationErrors []*ParseError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// socketTypes maps the socket type names used in configuration to gozmq's
+// socket types.
+var socketTypes = map[string]zmq.SocketType{
+	"PAIR":       zmq.PAIR,
+	"PUB":        zmq.PUB,
+	"SUB":        zmq.SUB,
+	"REQ":        zmq.REQ,
+	"REP":        zmq.REP,
+	"DEALER":     zmq.DEALER,
+	"ROUTER":     zmq.ROUTER,
+	"PULL":       zmq.PULL,
+	"PUSH":       zmq.PUSH,
+	"XPUB":       zmq.XPUB,
+	"XSUB":       zmq.XSUB,
+	"XREQ":       zmq.XREQ,
+	"XREP":       zmq.XREP,
+	"UPSTREAM":   zmq.UPSTREAM,
+	"DOWNSTREAM": zmq.DOWNSTREAM,
+}
+
+// Validate loads the named app's configuration from the sources as NewApp
+// does and checks it without creating any sockets.  Every problem it finds is
+// returned at once as ValidationErrors, including devices whose types have not
+// been registered, which NewApp leaves until the app is started.
+func Validate(appName string, sources ...interface{}) error {
+	appConf, problems, err := readApp(appName, sources...)
+	if err != nil {
+		return err
+	}
+	reported := newReportedKeys(problems)
+	problems = append(problems, validateApp(appName, appConf, reported)...)
+	for _, devName := range sortedKeys(appConf.Devices) {
+		devConf := appConf.Devices[devName]
+		if len(devConf.Type) > 0 && !reported[deviceKey(appName, devName)+"/type"] {
+			if _, ok := lookupDevice(devConf.Type); !ok {
+				problems = append(problems, unregisteredDevice(appName, devName, devConf.Type))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+func unregisteredDevice(appName, devName, devType string) *ParseError {
+	return &ParseError{
+		Key: deviceKey(appName, devName) + "/type",
+		Err: fmt.Errorf("unregistered device type: %s", devType),
+	}
+}
+
+func deviceKey(appName, devName string) string {
+	return "apps/" + appName + "/devices/" + devName
+}
+
+func sortedKeys(m interface{}) []string {
+	keys := reflect.ValueOf(m).MapKeys()
+	names := make([]string, len(keys))
+	for i, key := range keys {
+		names[i] = key.String()
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validateApp checks an app's merged configuration, which has no positions
+// in any source, so the problems it reports have keys but no line numbers.
+// Values whose keys have already been reported are not checked again.
+func validateApp(appName string, appConf *AppConfig, reported reportedKeys) (problems ValidationErrors) {
+	report := func(key, format string, args ...interface{}) {
+		problems = append(problems, &ParseError{Key: key, Err: fmt.Errorf(format, args...)})
+	}
+	if ctxConf := appConf.Context; ctxConf != nil {
+		key := "apps/" + appName + "/context"
+		if ctxConf.IoThreads < 0 {
+			report(key+"/iothreads", "iothreads must not be negative.")
+		}
+		if ctxConf.Linger != nil && *ctxConf.Linger < -1 {
+			report(key+"/linger", "linger must be -1 (forever) or more.")
+		}
+	}
+	for _, devName := range sortedKeys(appConf.Devices) {
+		var (
+			devConf = appConf.Devices[devName]
+			key     = deviceKey(appName, devName)
+		)
+		if len(devConf.Type) == 0 {
+			report(key+"/type", "missing device type.")
+		}
+		if _, err := newRestartPolicy(devConf.Restart); err != nil {
+			report(key+"/restart", "%s", err)
+		}
+		if spec, ok := lookupSpec(devConf.Type); ok && !reported[key+"/type"] {
+			problems = append(problems, checkSpec(key, devConf, spec, reported)...)
+		}
+		for _, sockName := range sortedKeys(devConf.Sockets) {
+			var (
+				sockConf = devConf.Sockets[sockName]
+				sockKey  = key + "/sockets/" + sockName
+			)
+			if len(sockConf.Type) == 0 {
+				report(sockKey+"/type", "missing socket type.")
+			} else if _, ok := socketTypes[sockConf.Type]; !ok && !reported[sockKey+"/type"] {
+				report(sockKey+"/type", "unknown socket type: %s", sockConf.Type)
+			}
+			endpoints := map[string][]string{
+				"bind":     sockConf.Bind,
+				"bind+":    sockConf.BindAppend,
+				"connect":  sockConf.Connect,
+				"connect+": sockConf.ConnectAppend,
+			}
+			for _, listName := range sortedKeys(endpoints) {
+				for i, endpoint := range endpoints[listName] {
+					listKey := sockKey + "/" + listName
+					if err := checkEndpoint(endpoint); err != nil && !reported[listKey+"/"+strconv.Itoa(i)] {
+						report(strings.TrimSuffix(listKey, "+"), "%s", err)
+					}
+				}
+			}
+		}
+	}
+	return problems
+}
+
+// checkSpec checks that a device has what the spec of its type says it needs.
+func checkSpec(key string, devConf *DeviceConfig, spec DeviceSpec, reported reportedKeys) (problems ValidationErrors) {
+	report := func(key, format string, args ...interface{}) {
+		problems = append(problems, &ParseError{Key: key, Err: fmt.Errorf(format, args...)})
+	}
+	for _, sockName := range spec.RequiredSockets {
+		if _, ok := devConf.Sockets[sockName]; !ok {
+			report(key+"/sockets", "missing socket %s, which %s devices require.", sockName, devConf.Type)
+		}
+	}
+	for _, sockName := range sortedKeys(spec.AllowedSocketTypes) {
+		sockConf, ok := devConf.Sockets[sockName]
+		if !ok || reported[key+"/sockets/"+sockName+"/type"] {
+			continue
+		}
+		if _, known := socketTypes[sockConf.Type]; !known {
+			continue // missing or unknown, which validateApp reports
+		}
+		allowed := spec.AllowedSocketTypes[sockName]
+		found := false
+		for _, sockType := range allowed {
+			found = found || sockType == sockConf.Type
+		}
+		if !found {
+			report(key+"/sockets/"+sockName+"/type", "%s devices cannot use a %s socket as %s, only %s.",
+				devConf.Type, sockConf.Type, sockName, strings.Join(allowed, " or "))
+		}
+	}
+	if spec.Params != nil {
+		t := reflect.TypeOf(spec.Params)
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		params := unreported(devConf.Params, key+"/params", reported)
+		if err := decodeParam(params, reflect.New(t).Elem(), ""); err != nil {
+			err.Key = strings.TrimSuffix(key+"/params/"+err.Key, "/")
+			problems = append(problems, err)
+		}
+	}
+	return problems
+}
+
+// unreported returns a copy of the params at key in which those that have
+// already been reported, such as values whose variables could not be
+// substituted, are unset, so that decoding them does not report them again.
+func unreported(value interface{}, key string, reported reportedKeys) interface{} {
+	if reported[key] {
+		return nil
+	}
+	switch value := value.(type) {
+	case map[string]interface{}:
+		section := make(map[string]interface{}, len(value))
+		for name, elem := range value {
+			section[name] = unreported(elem, key+"/"+name, reported)
+		}
+		return section
+	case []interface{}:
+		list := make([]interface{}, len(value))
+		for i, elem := range value {
+			list[i] = unreported(elem, key+"/"+strconv.Itoa(i), reported)
+		}
+		return list
+	}
+	return value
+}
+
+// reportedKeys is the set of keys of the problems already found in a
+// configuration, such as values whose variables could not be substituted.
+type reportedKeys map[string]bool
+
+func newReportedKeys(problems ValidationErrors) reportedKeys {
+	reported := reportedKeys{}
+	for _, problem := range problems {
+		reported[problem.Key] = true
+	}
+	return reported
+}
+
+// checkEndpoint checks that an endpoint is of the form transport://address
+// for one of the transports ØMQ supports.
+func checkEndpoint(endpoint string) error {
+	fail := func(problem string) error {
+		return fmt.Errorf("malformed endpoint %q: %s", endpoint, problem)
+	}
+	i := strings.Index(endpoint, "://")
+	if i < 0 {
+		return fail("expected transport://address")
+	}
+	transport, address := endpoint[:i], endpoint[i+3:]
+	if len(address) == 0 {
+		return fail("missing address")
+	}
+	switch transport {
+	case "tcp", "pgm", "epgm":
+		colon := strings.LastIndex(address, ":")
+		if colon <= 0 {
+			return fail("missing host or port")
+		}
+		if port := address[colon+1:]; port != "*" || transport != "tcp" {
+			if n, err := strconv.Atoi(port); err != nil || n < 1 || 65535 < n {
+				return fail("invalid port " + strconv.Quote(port))
+			}
+		}
+		if transport != "tcp" && !strings.Contains(address[:colon], ";") {
+			return fail("expected interface;multicast-address:port")
+		}
+	case "ipc", "inproc":
+	default:
+		return fail("unknown transport " + strconv.Quote(transport))
+	}
+	return nil
+}
+
+// unknownKeys reports each key in text that does not correspond to anything
+// in the type t, into which the text is decoded.
+func unknownKeys(text []byte, t reflect.Type) (problems ValidationErrors) {
+	unknown := errors.New("unknown key.")
+	if isJSON(text) {
+		walkJSON(text, func(path string, token json.Token, start, end int64) bool {
+			if len(path) == 0 {
+				return false
+			}
+			if names := strings.Split(path, "/"); unknownAt(t, names, "json") {
+				line, column := position(text, start)
+				problems = append(problems, &ParseError{Line: line, Column: column, Key: path, Err: unknown})
+			}
+			return false
+		})
+		return problems
+	}
+	root, err := scanZPL(text)
+	if err != nil {
+		return nil
+	}
+	var walk func(node *zplNode, names []string)
+	walk = func(node *zplNode, names []string) {
+		for _, child := range node.children {
+			path := append(names[:len(names):len(names)], child.name)
+			if unknownAt(t, path, "zpl") {
+				problems = append(problems, &ParseError{
+					Line:   child.line,
+					Column: child.column,
+					Key:    strings.Join(path, "/"),
+					Err:    unknown,
+				})
+			} else {
+				walk(child, path)
+			}
+		}
+	}
+	walk(root, nil)
+	return problems
+}
+
+// unknownAt reports whether the last name in path is the first that does not
+// correspond to anything in the type t.
+func unknownAt(t reflect.Type, path []string, tag string) bool {
+	_, unknown := keyType(t, path, tag)
+	return unknown == len(path)-1
+}
@@ -0,0 +1,221 @@
+// Copyright 2013 Joshua Tacoma. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zdcf
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	defaults := `
+version = 1.0
+apps
+    checked
+        context
+            linger = -2
+        devices
+            main
+                type = zmq_queue
+                restart
+                    policy = sometimes
+                sockets
+                    frontend
+                        type = ROUTER
+                        bind = tcp://*:5555
+                        option
+                            hwm = 100
+                            hmw = 1000
+                    backend
+                        type = DEALR
+                        bind = tcp://*:555555
+                        connect = tpc://127.0.0.1:5556
+            untyped
+                sockets
+                    out
+                        bind = ipc:///tmp/untyped
+                        colour = blue`
+	overlay := `{
+		"version": 1.0,
+		"apps": {
+			"checked": {
+				"devices": {
+					"extra": {"type": "no_such_device"}
+				},
+				"context": {"verbosity": 3}
+			}
+		}
+	}`
+	err := Validate("checked", defaults, overlay)
+	problems, ok := err.(ValidationErrors)
+	if !ok {
+		t.Fatalf("err = %v", err)
+	}
+	expected := []string{
+		"18:29: apps/checked/devices/main/sockets/frontend/option/hmw: unknown key.",
+		"27:25: apps/checked/devices/untyped/sockets/out/colour: unknown key.",
+		"8:30: apps/checked/context/verbosity: unknown key.",
+		"apps/checked/context/linger: linger must be -1",
+		"apps/checked/devices/extra/type: unregistered device type: no_such_device",
+		"apps/checked/devices/main/restart: unknown restart policy: sometimes",
+		"apps/checked/devices/main/sockets/backend/type: unknown socket type: DEALR",
+		`apps/checked/devices/main/sockets/backend/bind: malformed endpoint "tcp://*:555555"`,
+		`apps/checked/devices/main/sockets/backend/connect: malformed endpoint "tpc://127.0.0.1:5556"`,
+		"apps/checked/devices/untyped/type: missing device type.",
+		"apps/checked/devices/untyped/sockets/out/type: missing socket type.",
+	}
+	for _, message := range expected {
+		if !strings.Contains(err.Error(), message) {
+			t.Errorf("missing %q", message)
+		}
+	}
+	if len(problems) != len(expected) {
+		t.Errorf("err = %s", err)
+	}
+	if _, err = NewApp("checked", defaults, overlay); err == nil {
+		t.Errorf("created invalid app")
+	} else if strings.Contains(err.Error(), "unregistered") {
+		t.Errorf("NewApp checked device registration: %s", err)
+	}
+}
+
+func TestRegisterDevice(t *testing.T) {
+	type indexerParams struct {
+		Batch   int
+		Timeout time.Duration
+	}
+	err := RegisterDevice(DeviceSpec{
+		Pattern:            `^test_indexer$`,
+		RequiredSockets:    []string{"in", "out"},
+		AllowedSocketTypes: map[string][]string{"in": {"PULL", "SUB"}, "out": {"PUSH"}},
+		Params:             &indexerParams{},
+		Run:                func(dev *DeviceContext) error { return nil },
+	})
+	if err != nil {
+		t.Fatalf("failed to register: %s", err)
+	}
+	valid := `
+version = 1.0
+apps
+    indexer
+        devices
+            main
+                type = test_indexer
+                params
+                    batch = 500
+                    timeout = 2s
+                sockets
+                    in
+                        type = SUB
+                        connect = tcp://127.0.0.1:5555
+                    out
+                        type = ${OUT_TYPE}
+                        bind = tcp://*:5556
+                    monitor
+                        type = PUB
+                        bind = tcp://*:5557`
+	app, err := NewApp("indexer", valid, Params{"OUT_TYPE": "PUSH"})
+	if err != nil {
+		t.Fatalf("failed to create app: %s", err)
+	}
+	app.Close()
+	invalid := `{
+		"version": 1.0,
+		"apps": {
+			"indexer": {
+				"devices": {
+					"main": {
+						"params": {"batch": "many", "colour": "blue"},
+						"sockets": {"in": {"type": "PUB"}, "out": null}
+					}
+				}
+			}
+		}
+	}`
+	_, err = NewApp("indexer", valid, invalid, Params{"OUT_TYPE": "PUSH"})
+	expected := "apps/indexer/devices/main/sockets: missing socket out, which test_indexer devices require.; " +
+		"apps/indexer/devices/main/sockets/in/type: test_indexer devices cannot use a PUB socket as in, only PULL or SUB.; " +
+		`apps/indexer/devices/main/params/batch: cannot use "many" as int.`
+	if _, ok := err.(ValidationErrors); !ok || err.Error() != expected {
+		t.Errorf("got %v\nexpected %s", err, expected)
+	}
+	_, err = NewApp("indexer", valid, `{"version": 1.0, "apps": {"indexer": {"devices": {"main": {"params": {"colour": "blue"}}}}}}`,
+		Params{"OUT_TYPE": "PUB"})
+	expected = "apps/indexer/devices/main/sockets/out/type: test_indexer devices cannot use a PUB socket as out, only PUSH.; " +
+		"apps/indexer/devices/main/params/colour: unknown param."
+	if err == nil || err.Error() != expected {
+		t.Errorf("got %v\nexpected %s", err, expected)
+	}
+	_, err = NewApp("indexer", valid, `{"version": 1.0, "apps": {"indexer": {"devices": {"main": {"params": {"batch": "${BATCH}"}}}}}}`,
+		Params{"OUT_TYPE": "PUSH"})
+	expected = `apps/indexer/devices/main/params/batch: unresolved variable BATCH in "${BATCH}"`
+	if err == nil || err.Error() != expected {
+		t.Errorf("got %v\nexpected %s", err, expected)
+	}
+	if err = Validate("indexer", valid, invalid, Params{"OUT_TYPE": "PUSH"}); err == nil {
+		t.Errorf("Validate accepted what NewApp rejected")
+	}
+}
+
+func TestRegisterDevice_Errors(t *testing.T) {
+	run := func(dev *DeviceContext) error { return nil }
+	for _, spec := range []DeviceSpec{
+		{Pattern: `test_(`, Run: run},
+		{Pattern: `test_nothing`},
+		{Pattern: `test_socket`, AllowedSocketTypes: map[string][]string{"in": {"PULL", "PUL"}}, Run: run},
+		{Pattern: `test_params`, Params: map[string]int{}, Run: run},
+	} {
+		if err := RegisterDevice(spec); err == nil {
+			t.Errorf("%s: registered", spec.Pattern)
+		}
+	}
+}
+
+func TestApp_Start_Unregistered(t *testing.T) {
+	app, err := NewApp("unregistered", `
+version = 1.0
+apps
+    unregistered
+        devices
+            first
+                type = no_such_device
+            second
+                type = nor_this_one`)
+	if err != nil {
+		t.Fatalf("failed to create app: %s", err)
+	}
+	defer app.Close()
+	err = app.Start()
+	if problems, ok := err.(ValidationErrors); !ok || len(problems) != 2 {
+		t.Fatalf("err = %v", err)
+	}
+	for _, devContext := range app.Devices() {
+		if status, _ := devContext.Status(); status != DeviceIdle {
+			t.Errorf("%s: status = %v", devContext.Name(), status)
+		}
+	}
+}
+
+func TestCheckEndpoint(t *testing.T) {
+	for endpoint, valid := range map[string]bool{
+		"tcp://127.0.0.1:5555":         true,
+		"tcp://*:5555":                 true,
+		"tcp://eth0:*":                 true,
+		"ipc:///tmp/feed":              true,
+		"inproc://workers":             true,
+		"epgm://eth0;239.192.1.1:5555": true,
+		"pgm://239.192.1.1:5555":       false,
+		"tcp://127.0.0.1":              false,
+		"tcp://127.0.0.1:http":         false,
+		"127.0.0.1:5555":               false,
+		"inproc://":                    false,
+		"udp://127.0.0.1:5555":         false,
+	} {
+		if err := checkEndpoint(endpoint); (err == nil) != valid {
+			t.Errorf("%s: err = %v", endpoint, err)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+// Copyright 2013 Joshua Tacoma. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zdcf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The YAML format is read by gopkg.in/yaml.v3, from a single document.
+func init() {
+	RegisterFormat("yaml", sniffYAML, decodeYAML, encodeYAML)
+	RegisterFormat("yml", nil, decodeYAML, encodeYAML)
+}
+
+var yamlKey = regexp.MustCompile(`^("[^"]*"|'[^']*'|[^-?:,\[\]{}#&*!|>'"%@\x60\s][^=#:]*):(\s|$)`)
+
+// sniffYAML reports whether text looks like YAML, which is when its first
+// line, comments aside, starts a document, a sequence or a mapping.  A ZPL name
+// cannot be followed by a colon or contain a space, so of these only a section
+// named --- could be ZPL, and is read as ZPL only from a .zdcf or .zpl file.
+func sniffYAML(text []byte) bool {
+	for _, line := range strings.Split(string(text), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		return line == "---" || line == "-" || strings.HasPrefix(line, "- ") || yamlKey.MatchString(line)
+	}
+	return false
+}
+
+var yamlErrorLine = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+func decodeYAML(text []byte) (interface{}, error) {
+	var (
+		v       interface{}
+		decoder = yaml.NewDecoder(bytes.NewReader(text))
+	)
+	if err := decoder.Decode(&v); err != nil && err != io.EOF {
+		return nil, yamlError(err)
+	}
+	var next interface{}
+	if err := decoder.Decode(&next); err != io.EOF {
+		return nil, &ParseError{Err: errors.New("multiple documents are not supported.")}
+	}
+	return yamlStrings(v), nil
+}
+
+// yamlError converts an error from gopkg.in/yaml.v3 into a ParseError that
+// gives the line of the first problem it reports.
+func yamlError(err error) error {
+	message := err.Error()
+	if typeErr, ok := err.(*yaml.TypeError); ok && len(typeErr.Errors) > 0 {
+		message = typeErr.Errors[0]
+	}
+	m := yamlErrorLine.FindStringSubmatch(message)
+	if m == nil {
+		return &ParseError{Err: errors.New(strings.TrimPrefix(message, "yaml: ") + ".")}
+	}
+	line, _ := strconv.Atoi(m[1])
+	return &ParseError{Line: line, Err: errors.New(m[2] + ".")}
+}
+
+// yamlStrings returns v with the keys of its mappings, which YAML allows to be
+// of any type, converted to strings as encoding/json would have them.
+func yamlStrings(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = yamlStrings(value)
+		}
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = yamlStrings(value)
+		}
+		return m
+	case []interface{}:
+		for i, item := range v {
+			v[i] = yamlStrings(item)
+		}
+	}
+	return v
+}
+
+// encodeYAML writes a configuration as YAML, in the same order and with the
+// same guarantee as marshalJSON.  A nil device or socket is written as null.
+func encodeYAML(conf *Config) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeYAML(&buf, reflect.ValueOf(conf), 0); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeYAML writes the fields of a struct, or the entries of a map, as a
+// block mapping at the given depth of indentation.
+func writeYAML(buf *bytes.Buffer, v reflect.Value, depth int) error {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+			if len(name) == 0 || name == "-" {
+				continue
+			}
+			if err := writeYAMLEntry(buf, name, v.Field(i), depth); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		for _, name := range sortedKeys(v.Interface()) {
+			value := v.MapIndex(reflect.ValueOf(name).Convert(v.Type().Key()))
+			if value.Kind() == reflect.Ptr && value.IsNil() {
+				writeYAMLKey(buf, name, depth)
+				buf.WriteString(" null\n")
+				continue
+			}
+			if err := writeYAMLEntry(buf, name, value, depth); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("cannot write %s as a YAML mapping.", v.Type())
+	}
+	return nil
+}
+
+// writeYAMLEntry writes a named value, which may be a mapping, a sequence or
+// a scalar.  Zero values, other than those pointed to, are not written.
+func writeYAMLEntry(buf *bytes.Buffer, name string, v reflect.Value, depth int) error {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		if elem := v.Elem(); elem.Kind() != reflect.Struct && elem.Kind() != reflect.Map {
+			return writeYAMLScalar(buf, name, elem, depth)
+		}
+		return writeYAMLEntry(buf, name, v.Elem(), depth)
+	case reflect.Struct, reflect.Map:
+		if v.Kind() == reflect.Map && v.Len() == 0 {
+			return nil
+		}
+		var inner bytes.Buffer
+		if err := writeYAML(&inner, v, depth+1); err != nil {
+			return err
+		}
+		writeYAMLKey(buf, name, depth)
+		if inner.Len() == 0 {
+			buf.WriteString(" {}\n")
+			return nil
+		}
+		buf.WriteByte('\n')
+		buf.Write(inner.Bytes())
+		return nil
+	case reflect.Slice:
+		if v.Len() == 0 {
+			return nil
+		}
+		writeYAMLKey(buf, name, depth)
+		buf.WriteByte('\n')
+		for i := 0; i < v.Len(); i++ {
+			value, err := yamlValue(v.Index(i))
+			if err != nil {
+				return fmt.Errorf("%s: %s", name, err)
+			}
+			buf.WriteString(strings.Repeat("  ", depth+1))
+			buf.WriteString("- " + value + "\n")
+		}
+		return nil
+	}
+	if v.IsZero() {
+		return nil
+	}
+	return writeYAMLScalar(buf, name, v, depth)
+}
+
+func writeYAMLScalar(buf *bytes.Buffer, name string, v reflect.Value, depth int) error {
+	value, err := yamlValue(v)
+	if err != nil {
+		return fmt.Errorf("%s: %s", name, err)
+	}
+	writeYAMLKey(buf, name, depth)
+	buf.WriteString(" " + value + "\n")
+	return nil
+}
+
+func writeYAMLKey(buf *bytes.Buffer, name string, depth int) {
+	buf.WriteString(strings.Repeat("  ", depth))
+	buf.WriteString(yamlString(name))
+	buf.WriteByte(':')
+}
+
+func yamlValue(v reflect.Value) (string, error) {
+	switch v.Kind() {
+	case reflect.String:
+		return yamlString(v.String()), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits()), nil
+	}
+	return "", fmt.Errorf("cannot write %s as a YAML scalar.", v.Type())
+}
+
+// yamlString returns s as a plain scalar if it would be read back as the same
+// string, and otherwise quoted.
+func yamlString(s string) string {
+	if len(s) == 0 || s != strings.TrimSpace(s) || strconv.Quote(s) != `"`+s+`"` ||
+		strings.ContainsAny(s[:1], "-?:,[]{}#&*!|>'%@`") ||
+		strings.Contains(s, ": ") || strings.Contains(s, " #") || strings.HasSuffix(s, ":") {
+		return strconv.Quote(s)
+	}
+	var v interface{}
+	if err := yaml.Unmarshal([]byte(s), &v); err != nil || v != interface{}(s) {
+		return strconv.Quote(s)
+	}
+	return s
+}
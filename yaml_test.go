@@ -0,0 +1,98 @@
+// Copyright 2013 Joshua Tacoma. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zdcf
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeYAML(t *testing.T) {
+	type m = map[string]interface{}
+	type l = []interface{}
+	for _, test := range []struct {
+		text     string
+		expected interface{}
+	}{
+		{"", nil},
+		{"# nothing\n---\n", nil},
+		{"version: 1.0", m{"version": 1.0}},
+		{"port: 5555\nname: 'it''s'\nok: true\ngone: ~", m{"port": 5555, "name": "it's", "ok": true, "gone": nil}},
+		{"bind: tcp://eth0:5555 # a comment", m{"bind": "tcp://eth0:5555"}},
+		{"identity: \"a # b\\tc\\u00e9\"", m{"identity": "a # b\tc\u00e9"}},
+		{`
+apps:
+  listener:
+    devices:
+      main:
+        sockets:
+          frontend:
+            bind:
+            - tcp://eth0:5555
+            - tcp://eth1:5555
+            "bind+": [ipc://a, 'ipc://b']
+`, m{"apps": m{"listener": m{"devices": m{"main": m{"sockets": m{"frontend": m{
+			"bind":  l{"tcp://eth0:5555", "tcp://eth1:5555"},
+			"bind+": l{"ipc://a", "ipc://b"},
+		}}}}}}}},
+		{"option: {hwm: 1000, subscribe: [a,\n    b]}\nempty: {}", m{
+			"option": m{"hwm": 1000, "subscribe": l{"a", "b"}},
+			"empty":  m{},
+		}},
+		{"list:\n  - a: 1\n    b: 2\n  -\n    - x\n  - - y", m{"list": l{m{"a": 1, "b": 2}, l{"x"}, l{"y"}}}},
+		{"base: &base {hwm: 1000}\nother: *base\n1: one", m{"base": m{"hwm": 1000}, "other": m{"hwm": 1000}, "1": "one"}},
+		{"identity: |\n  two\n  lines", m{"identity": "two\nlines"}},
+	} {
+		v, err := decodeYAML([]byte(test.text))
+		if err != nil {
+			t.Errorf("%q: %s", test.text, err)
+		} else if !reflect.DeepEqual(v, test.expected) {
+			t.Errorf("%q: got %#v", test.text, v)
+		}
+	}
+}
+
+func TestDecodeYAML_Errors(t *testing.T) {
+	for _, test := range []struct {
+		text     string
+		line     int
+		contains string
+	}{
+		{"a: 1\n  b: 2", 2, "mapping values are not allowed"},
+		{"a: 1\na: 2", 2, "already defined"},
+		{"a: 1\nb", 2, "could not find expected ':'"},
+		{"a:\n\t- b", 2, "cannot start any token"},
+		{"a: [b, c", 1, "did not find expected ',' or ']'"},
+		{"a: 1\n---\nb: 2", 0, "multiple documents"},
+	} {
+		_, err := decodeYAML([]byte(test.text))
+		if err == nil {
+			t.Errorf("%q: decoded without error", test.text)
+			continue
+		}
+		parseErr, ok := err.(*ParseError)
+		if !ok || parseErr.Line != test.line || !strings.Contains(err.Error(), test.contains) {
+			t.Errorf("%q: err = %s", test.text, err)
+		}
+	}
+}
+
+func TestSniffYAML(t *testing.T) {
+	for text, expected := range map[string]bool{
+		"# comment\nversion: 1.0":    true,
+		"---\n":                      true,
+		"- a":                        true,
+		"version = 1.0\napps\n    x": false,
+		"[apps]":                     false,
+		"identity = a: b":            false,
+		"\"bind+\": [ipc://a]":       true,
+		"":                           false,
+	} {
+		if sniffYAML([]byte(text)) != expected {
+			t.Errorf("%q: expected %v", text, expected)
+		}
+	}
+}
@@ -6,170 +6,569 @@
 // (ZDCF, http://rfc.zeromq.org/spec:17) for ØMQ (ZeroMQ, ZMQ,
 // http://www.zeromq.org/) applications that use gozmq
 // (http://godoc.org/github.com/alecthomas/gozmq).
-//
 package zdcf
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sort"
 	"sync"
+	"syscall"
 
 	zmq "github.com/alecthomas/gozmq"
 )
 
+// ListenAndServe runs the named app until all of its devices have returned.
+//
+// If any devices fail, the error is a DeviceErrors as returned by App.Wait.
 func ListenAndServe(appName string, sources ...interface{}) error {
-	var (
-		wg  sync.WaitGroup
-		app *app
-		err error
-	)
-	app, err = newApp(appName, sources...)
+	return ListenAndServeContext(context.Background(), appName, sources...)
+}
+
+// ListenAndServeContext runs the named app until all of its devices have
+// returned or ctx is done.
+//
+// When ctx is done, the app is stopped as by App.Stop and
+// ListenAndServeContext returns ctx.Err(), unless devices have failed.
+func ListenAndServeContext(ctx context.Context, appName string, sources ...interface{}) error {
+	app, err := NewApp(appName, sources...)
 	if err != nil {
 		return fmt.Errorf("while creating app: %s", err)
 	}
 	defer app.Close()
-	var runners []func()
-	app.ForDevices(func(ctx *DeviceContext) {
-		var (
-			dev func(*DeviceContext)
-			ok  bool
-		)
-		if err == nil {
-			if dev, ok = lookupDevice(ctx.Type()); ok {
-				runners = append(runners, func() {
-					dev(ctx)
-					wg.Done()
-				})
-			} else {
-				err = fmt.Errorf("unregistered device type: %s", ctx.Type())
-			}
-		}
-	})
+	if err = app.Start(); err != nil {
+		return err
+	}
+	finished := make(chan error, 1)
+	go func() {
+		finished <- app.Wait()
+	}()
+	select {
+	case err = <-finished:
+	case <-ctx.Done():
+		app.Stop()
+		err = <-finished
+	}
 	if err != nil {
 		return err
 	}
-	if len(runners) == 0 {
-		return fmt.Errorf("no devices loaded.")
+	return ctx.Err()
+}
+
+// An App is a ØMQ context with a collection of devices.
+type App struct {
+	context   zmq.Context
+	name      string
+	conf      *AppConfig
+	devices   map[string]*DeviceContext
+	verbose   bool
+	closing   sync.Once
+	mutex     sync.Mutex
+	reloading sync.Mutex
+	started   bool
+	stopped   bool
+	halted    chan struct{}
+	running   sync.WaitGroup
+	failing   sync.Mutex
+	failed    DeviceErrors
+}
+
+// zmqNewContext creates the ØMQ context of each app.  Tests replace it in
+// order to see what is done with the context.
+var zmqNewContext = zmq.NewContext
+
+// newContext creates a ØMQ context with the configured settings for the named
+// app.
+//
+// gozmq does not provide a way to set the number of I/O threads on every
+// version it supports, so a count other than ØMQ's default of 1 is logged and
+// ignored unless the context has a SetIOThreads method.
+func newContext(appName string, conf *ContextConfig) (zmq.Context, error) {
+	context, err := zmqNewContext()
+	if err != nil {
+		return nil, err
 	}
-	for _, run := range runners {
-		wg.Add(1)
-		go run()
+	if conf == nil || conf.IoThreads == 0 {
+		return context, nil
 	}
-	wg.Wait()
-	return nil
+	if setter, ok := context.(interface {
+		SetIOThreads(int) error
+	}); ok {
+		if err = setter.SetIOThreads(conf.IoThreads); err != nil {
+			context.Close()
+			return nil, fmt.Errorf("could not set iothreads = %d : %s",
+				conf.IoThreads, err)
+		}
+	} else if conf.IoThreads != 1 {
+		log.Printf("zdcf: %s: warning: this version of gozmq cannot set iothreads = %d; using 1.",
+			appName, conf.IoThreads)
+	}
+	return context, nil
+}
+
+// NewApp creates the named app based on the specified configuration.
+//
+// Each source is ZDCF text in JSON or ZPL, given as a string, a []byte or an
+// io.Reader, or else the name of a File, a Dir or a Glob to read it from, or a
+// *Config or one of the builders returned by NewConfig.  When there are several
+// sources, each later source is merged into the earlier ones.  Params among the
+// sources give values for the variables used in the others.
+func NewApp(appName string, sources ...interface{}) (a *App, err error) {
+	var appConf *AppConfig
+	if appConf, err = loadApp(appName, sources...); err != nil {
+		return nil, err
+	}
+	if context, err := newContext(appName, appConf.Context); err != nil {
+		return nil, err
+	} else {
+		a = &App{
+			context: context,
+			name:    appName,
+			conf:    appConf,
+			devices: map[string]*DeviceContext{},
+			verbose: appConf.Context.verbose(),
+			halted:  make(chan struct{}),
+		}
+	}
+	for devName, devConf := range appConf.Devices {
+		if a.devices[devName], err = a.newDevice(devName, devConf, appConf.Context); err != nil {
+			a.Close()
+			return nil, err
+		}
+	}
+	return a, nil
 }
 
-// An app is a ØMQ context with a collection of devices.
-type app struct {
-	context zmq.Context
-	name    string
-	devices map[string]*DeviceContext
+// loadApp parses and merges the sources and returns the named app's
+// configuration, or ValidationErrors if there is anything wrong with it.
+func loadApp(appName string, sources ...interface{}) (*AppConfig, error) {
+	appConf, problems, err := readApp(appName, sources...)
+	if err != nil {
+		return nil, err
+	}
+	if problems = append(problems, validateApp(appName, appConf, newReportedKeys(problems))...); len(problems) > 0 {
+		return nil, problems
+	}
+	return appConf, nil
 }
 
-// Create the named app based on the specified configuration.
-func newApp(appName string, sources ...interface{}) (a *app, err error) {
+// readApp parses and merges the sources, and the files they include, and
+// returns the named app's configuration, with devices that extend others
+// resolved and variables substituted from any Params among them, along with
+// any unknown keys, unresolved variables and unresolvable devices found in the
+// sources.
+func readApp(appName string, sources ...interface{}) (*AppConfig, ValidationErrors, error) {
 	var (
-		conf    *zdcf1
-		appConf *app1
-		ok      bool
+		conf     = &Config{Version: 1.0}
+		params   = Params{}
+		problems ValidationErrors
 	)
+	if len(sources) == 0 {
+		return nil, nil, errors.New("no configuration sources.")
+	}
 	for _, source := range sources {
-		var next *zdcf1
-		if _, ok := source.(string); ok {
-			source = []byte(source.(string))
+		if more, ok := source.(Params); ok {
+			for name, value := range more {
+				params[name] = value
+			}
+			continue
 		}
-		switch source.(type) {
-		case []byte:
-			next, err = unmarshalZdcf1(source.([]byte))
+		var nexts []*Config
+		if builder, ok := source.(interface {
+			Config() *Config
+		}); ok {
+			source = builder.Config()
+		}
+		if next, ok := source.(*Config); ok {
+			more, unknown, err := includes(appName, next, "", nil)
 			if err != nil {
-				conf0, err0 := unmarshalZdcf0(source.([]byte))
-				if err0 != nil {
-					return nil, err0
+				return nil, nil, err
+			}
+			nexts, problems = append(nexts, more...), append(problems, unknown...)
+		} else if texts, ok, err := readSource(source); !ok {
+			return nil, nil, errors.New("unsupported configuration source.")
+		} else if err != nil {
+			return nil, nil, err
+		} else {
+			for _, text := range texts {
+				next, unknown, err := parseZdcf(appName, text.name, text.text)
+				if err != nil {
+					return nil, nil, sourceError(text.name, err)
 				}
-				next = conf0.zdcf1(appName)
-				err = nil
+				problems = append(problems, withSource(text.name, unknown)...)
+				var stack []string
+				if name, err := filepath.Abs(text.name); len(text.name) > 0 && err == nil {
+					stack = push(stack, name)
+				}
+				more, unknown, err := includes(appName, next, text.name, stack)
+				if err != nil {
+					return nil, nil, err
+				}
+				nexts, problems = append(nexts, more...), append(problems, unknown...)
 			}
-		case *zdcf1:
-			next = source.(*zdcf1)
 		}
-		if next == nil {
-			return nil, errors.New("unsupported configuration source.")
+		for _, next := range nexts {
+			if err := conf.update(next); err != nil {
+				return nil, nil, err
+			}
 		}
-		if conf == nil {
-			conf = next
-		} else {
-			conf.update(next)
+	}
+	appConf, ok := conf.Apps[appName]
+	if !ok {
+		return nil, nil, errors.New(fmt.Sprintf("no such app: %s", appName))
+	}
+	problems = append(problems, resolveExtends(appName, appConf)...)
+	problems = append(problems, interpolate(
+		reflect.ValueOf(appConf), "apps/"+appName, lookupVariable(params))...)
+	return appConf, problems, nil
+}
+
+// newDevice creates a DeviceContext for the app from the device's
+// configuration and that of the app's context.
+func (a *App) newDevice(devName string, devConf *DeviceConfig, ctxConf *ContextConfig) (*DeviceContext, error) {
+	var err error
+	devContext := &DeviceContext{
+		app:     a,
+		name:    devName,
+		conf:    devConf,
+		sockets: map[string]*socketContext{},
+		typ:     devConf.Type,
+		done:    make(chan struct{}),
+	}
+	if devContext.restart, err = newRestartPolicy(devConf.Restart); err != nil {
+		return nil, fmt.Errorf("device %s: %s", devName, err)
+	}
+	for sockName, sockConf := range devConf.Sockets {
+		sockContext := newSocketContext(devContext, sockName)
+		sockContext.Type = socketTypes[sockConf.Type]
+		sockContext.setOptions(sockConf.Options)
+		if ctxConf != nil && ctxConf.Linger != nil {
+			sockContext.IntOptions[zmq.LINGER] = *ctxConf.Linger
 		}
+		sockContext.Bind = joinList(sockConf.Bind, sockConf.BindAppend)
+		sockContext.Connect = joinList(sockConf.Connect, sockConf.ConnectAppend)
+		devContext.sockets[sockName] = sockContext
 	}
-	if context, err := zmq.NewContext(); err != nil {
-		return nil, err
-	} else {
-		a = &app{
-			context: context,
-			name:    appName,
-			devices: map[string]*DeviceContext{},
+	return devContext, nil
+}
+
+// Name returns the name of the app.
+func (a *App) Name() string { return a.name }
+
+// Devices returns the app's devices, sorted by name.
+func (a *App) Devices() []*DeviceContext {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.sortedDevices()
+}
+
+// sortedDevices returns the app's devices, sorted by name.  The caller must
+// hold a.mutex.
+func (a *App) sortedDevices() []*DeviceContext {
+	devices := make([]*DeviceContext, 0, len(a.devices))
+	for _, devContext := range a.devices {
+		devices = append(devices, devContext)
+	}
+	sort.Sort(byName(devices))
+	return devices
+}
+
+type byName []*DeviceContext
+
+func (s byName) Len() int           { return len(s) }
+func (s byName) Less(i, j int) bool { return s[i].name < s[j].name }
+func (s byName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// ForDevices calls the given function on each device.
+func (a *App) ForDevices(do func(*DeviceContext)) {
+	for _, devContext := range a.Devices() {
+		do(devContext)
+	}
+}
+
+// device returns the named device.
+func (a *App) device(name string) (*DeviceContext, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if devContext, ok := a.devices[name]; ok {
+		return devContext, nil
+	}
+	return nil, fmt.Errorf("no such device: %s", name)
+}
+
+// Start runs each of the app's devices in its own goroutine.
+//
+// It is an error to start an app more than once or after it has been stopped,
+// or to start an app with a device whose type has not been registered with
+// DeviceFunc, DeviceErrFunc or RegisterDevice; every such device is listed in
+// the ValidationErrors returned, and none of the app's devices are started.
+func (a *App) Start() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.stopped {
+		return errors.New("app already stopped.")
+	}
+	if a.started {
+		return errors.New("app already started.")
+	}
+	if len(a.devices) == 0 {
+		return errors.New("no devices loaded.")
+	}
+	var (
+		runners  = make(map[*DeviceContext]func(*DeviceContext) error)
+		problems ValidationErrors
+	)
+	for _, devContext := range a.sortedDevices() {
+		dev, ok := lookupDevice(devContext.Type())
+		if !ok {
+			problems = append(problems, unregisteredDevice(a.name, devContext.name, devContext.Type()))
+		}
+		runners[devContext] = dev
+	}
+	if len(problems) > 0 {
+		return problems
+	}
+	a.started = true
+	for devContext, dev := range runners {
+		a.run(devContext, dev)
+	}
+	return nil
+}
+
+// run runs the device in a new goroutine.  The caller must hold a.mutex.
+func (a *App) run(devContext *DeviceContext, dev func(*DeviceContext) error) {
+	finished := devContext.reset()
+	a.running.Add(1)
+	go func() {
+		defer a.running.Done()
+		defer close(finished)
+		if err := superviseDevice(dev, devContext); err != nil {
+			a.failing.Lock()
+			a.failed = append(a.failed, &DeviceError{devContext.name, err})
+			a.failing.Unlock()
 		}
+	}()
+}
+
+// StartDevice runs the named device, which must not be running, in its own
+// goroutine on the app's ØMQ context.
+//
+// It is an error to start a device after the app has been stopped.
+func (a *App) StartDevice(name string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.stopped {
+		return errors.New("app already stopped.")
 	}
-	appConf, ok = conf.Apps[appName]
+	devContext, ok := a.devices[name]
 	if !ok {
-		return nil, errors.New(fmt.Sprintf("no such app: %s", appName))
+		return fmt.Errorf("no such device: %s", name)
+	}
+	if devContext.running() {
+		return fmt.Errorf("device %s is already running.", name)
 	}
-	// TODO: context options (gozmq has no API for this yet)
+	dev, ok := lookupDevice(devContext.Type())
+	if !ok {
+		return fmt.Errorf("unregistered device type: %s", devContext.Type())
+	}
+	a.started = true
+	a.run(devContext, dev)
+	return nil
+}
+
+// StopDevice tells the named device to stop and waits for it to return.
+//
+// The device's Done channel is closed but, unlike Stop, the app's ØMQ context
+// is not terminated, so a device that blocks on socket operations should use
+// timeouts or polling in order to watch for it.  The builtin devices do.
+func (a *App) StopDevice(name string) error {
+	devContext, err := a.device(name)
+	if err != nil {
+		return err
+	}
+	finished := devContext.stop()
+	if finished == nil {
+		return fmt.Errorf("device %s is not running.", name)
+	}
+	<-finished
+	return nil
+}
+
+// RestartDevice stops the named device, if it is running, and starts it
+// again with fresh sockets.
+func (a *App) RestartDevice(name string) error {
+	if _, err := a.device(name); err != nil {
+		return err
+	}
+	// Wait must not return while the device is between stopping and starting.
+	a.running.Add(1)
+	defer a.running.Done()
+	a.StopDevice(name)
+	return a.StartDevice(name)
+}
+
+// Stop tells the app's devices to stop.
+//
+// Each device's Done channel is closed and the app's ØMQ context is terminated
+// so that blocking socket operations fail with ETERM.  Sockets that a device
+// leaves open are closed after it returns, subject to the linger configured
+// for the app's context.  Stop returns once all sockets have been closed.
+func (a *App) Stop() {
+	a.mutex.Lock()
+	if !a.stopped {
+		a.stopped = true
+		close(a.halted)
+	}
+	for _, devContext := range a.devices {
+		devContext.stop()
+	}
+	a.mutex.Unlock()
+	a.Close()
+}
+
+// Reload parses the sources again and applies the result to the app.
+//
+// Devices whose configuration has not changed keep running undisturbed.
+// Devices that have been removed are stopped, and devices that have been added
+// are started if the app has been started.  Devices whose type, sockets,
+// socket options, bind or connect lists, or restart policy have changed are
+// stopped and, if they were running, started again.  Of the app's context
+// settings, only linger can be changed by a reload: it affects every device.
+func (a *App) Reload(sources ...interface{}) error {
+	// Wait must not return while changed devices are between stopping and
+	// starting again.
+	a.running.Add(1)
+	defer a.running.Done()
+	appConf, err := loadApp(a.name, sources...)
+	if err != nil {
+		return err
+	}
+	a.reloading.Lock()
+	defer a.reloading.Unlock()
+	a.mutex.Lock()
+	if a.stopped {
+		a.mutex.Unlock()
+		return errors.New("app already stopped.")
+	}
+	var ctx0, ctx ContextConfig
+	if a.conf.Context != nil {
+		ctx0 = *a.conf.Context
+	}
+	if appConf.Context != nil {
+		ctx = *appConf.Context
+	}
+	if ctx0.IoThreads != ctx.IoThreads || ctx0.verbose() != ctx.verbose() {
+		a.mutex.Unlock()
+		return errors.New("context settings other than linger cannot be changed by a reload.")
+	}
+	var (
+		lingerChanged = !reflect.DeepEqual(ctx0.Linger, ctx.Linger)
+		changed       = map[string]*DeviceContext{}
+		runners       = map[string]func(*DeviceContext) error{}
+		wasRunning    = map[string]bool{}
+		stopping      = map[string]chan struct{}{}
+	)
 	for devName, devConf := range appConf.Devices {
-		devContext := &DeviceContext{
-			app:     a,
-			name:    devName,
-			sockets: map[string]*socketContext{},
-			typ:     devConf.Type,
+		devContext0, ok := a.devices[devName]
+		if ok && !lingerChanged && reflect.DeepEqual(devContext0.conf, devConf) {
+			continue
 		}
-		for sockName, sockConf := range devConf.Sockets {
-			sockContext := newSocketContext(devContext, sockName)
-			switch sockConf.Type {
-			case "PAIR":
-				sockContext.Type = zmq.PAIR
-			case "PUB":
-				sockContext.Type = zmq.PUB
-			case "SUB":
-				sockContext.Type = zmq.SUB
-			case "REQ":
-				sockContext.Type = zmq.REQ
-			case "REP":
-				sockContext.Type = zmq.REP
-			case "DEALER":
-				sockContext.Type = zmq.DEALER
-			case "ROUTER":
-				sockContext.Type = zmq.ROUTER
-			case "PULL":
-				sockContext.Type = zmq.PULL
-			case "PUSH":
-				sockContext.Type = zmq.PUSH
-			case "XPUB":
-				sockContext.Type = zmq.XPUB
-			case "XSUB":
-				sockContext.Type = zmq.XSUB
-			case "XREQ":
-				sockContext.Type = zmq.XREQ
-			case "XREP":
-				sockContext.Type = zmq.XREP
-			case "UPSTREAM":
-				sockContext.Type = zmq.UPSTREAM
-			case "DOWNSTREAM":
-				sockContext.Type = zmq.DOWNSTREAM
+		devContext, err := a.newDevice(devName, devConf, appConf.Context)
+		if err != nil {
+			a.mutex.Unlock()
+			return err
+		}
+		if a.started {
+			dev, ok := lookupDevice(devContext.Type())
+			if !ok {
+				a.mutex.Unlock()
+				return fmt.Errorf("unregistered device type: %s", devContext.Type())
+			}
+			runners[devName] = dev
+		}
+		changed[devName] = devContext
+	}
+	for devName, devContext0 := range a.devices {
+		if _, ok := appConf.Devices[devName]; ok {
+			if _, ok = changed[devName]; !ok {
+				continue
 			}
-			// TODO: socket options
-			sockContext.Bind = sockConf.Bind       // TODO: copy
-			sockContext.Connect = sockConf.Connect // TODO: copy
-			devContext.sockets[sockName] = sockContext
 		}
+		wasRunning[devName] = devContext0.running()
+		stopping[devName] = devContext0.stop()
+		delete(a.devices, devName)
+	}
+	a.mutex.Unlock()
+	// Devices that do not watch Done return only when the app is stopped, which
+	// must not be prevented by waiting for them here.
+	for devName, finished := range stopping {
+		if finished != nil {
+			<-finished
+		}
+		a.logf("%s: stopped for reload", devName)
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for devName, devContext := range changed {
 		a.devices[devName] = devContext
+		running, existed := wasRunning[devName]
+		if dev, ok := runners[devName]; ok && !a.stopped && (running || !existed) {
+			a.run(devContext, dev)
+			a.logf("%s: started after reload", devName)
+		}
 	}
-	return a, nil
+	a.conf = appConf
+	return nil
 }
 
-// ForDevices calls the given function on each device.
-func (a *app) ForDevices(do func(*DeviceContext)) {
-	for _, devContext := range a.devices {
-		do(devContext)
+// ReloadOnHangup reloads the app from the sources, as by Reload, each time the
+// process receives SIGHUP until the app is stopped.  Reloads that fail are
+// logged and leave the app as it was.
+func (a *App) ReloadOnHangup(sources ...interface{}) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(signals)
+		for {
+			select {
+			case <-signals:
+				if err := a.Reload(sources...); err != nil {
+					log.Printf("zdcf: %s: reload failed: %s", a.name, err)
+				}
+			case <-a.halted:
+				return
+			}
+		}
+	}()
+}
+
+// Wait blocks until all of the app's devices have returned.
+//
+// If any devices fail, by returning an error or by panicking, the error is a
+// DeviceErrors naming each of them.  A device that is configured with a
+// restart policy is only considered to have failed once it will not be
+// restarted again.
+func (a *App) Wait() error {
+	a.running.Wait()
+	a.failing.Lock()
+	defer a.failing.Unlock()
+	if len(a.failed) > 0 {
+		return a.failed
+	}
+	return nil
+}
+
+// logf writes a diagnostic message to the standard logger if the app's context
+// is configured to be verbose.
+func (a *App) logf(format string, v ...interface{}) {
+	if a.verbose {
+		log.Printf("zdcf: %s: "+format, append([]interface{}{a.name}, v...)...)
 	}
 }
 
@@ -178,18 +577,146 @@ func (a *app) ForDevices(do func(*DeviceContext)) {
 // Note that this is constrained by ØMQ's rules for the destruction of its
 // contexts, especially that a call to this method will block until all its
 // devices' sockets have been closed.
-func (a *app) Close() {
+func (a *App) Close() {
 	if a != nil && a.context != nil {
-		a.context.Close()
+		a.closing.Do(a.context.Close)
 	}
 }
 
 // A DeviceContext is intended to be all that a ØMQ device needs to do its job.
 type DeviceContext struct {
-	app     *app
-	name    string
-	typ     string
-	sockets map[string]*socketContext
+	app      *App
+	name     string
+	conf     *DeviceConfig
+	typ      string
+	sockets  map[string]*socketContext
+	done     chan struct{}
+	finished chan struct{}
+	mutex    sync.Mutex
+	open     []zmq.Socket
+	restart  restartPolicy
+	status   DeviceStatus
+	err      error
+}
+
+// A DeviceStatus describes what a device is doing.
+type DeviceStatus int
+
+const (
+	DeviceIdle       DeviceStatus = iota // not yet started
+	DeviceRunning                        // running
+	DeviceRestarting                     // waiting to be restarted
+	DeviceStopped                        // returned without failing
+	DeviceFailed                         // failed and will not be restarted
+)
+
+var deviceStatusNames = []string{"idle", "running", "restarting", "stopped", "failed"}
+
+func (s DeviceStatus) String() string {
+	if s < 0 || int(s) >= len(deviceStatusNames) {
+		return fmt.Sprintf("DeviceStatus(%d)", int(s))
+	}
+	return deviceStatusNames[s]
+}
+
+// Name returns the name of the device.
+func (d *DeviceContext) Name() string { return d.name }
+
+// Status returns the device's status and, if it has failed or is waiting to
+// be restarted, the error from its last run.
+func (d *DeviceContext) Status() (DeviceStatus, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.status, d.err
+}
+
+func (d *DeviceContext) setStatus(status DeviceStatus, err error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.status, d.err = status, err
+}
+
+// finish records the final status of the device and returns err.
+func (d *DeviceContext) finish(err error) error {
+	if err != nil {
+		d.setStatus(DeviceFailed, err)
+	} else {
+		d.setStatus(DeviceStopped, nil)
+	}
+	return err
+}
+
+// Done returns a channel that is closed when the device should stop.
+//
+// Devices that block on socket operations need not watch this channel when the
+// whole app is stopped, since their sockets will fail with ETERM once the app's
+// ØMQ context is terminated, but they must watch it in order to be stopped on
+// their own by App.StopDevice.
+func (d *DeviceContext) Done() <-chan struct{} {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.done
+}
+
+// stopping reports whether the device has been told to stop.
+func (d *DeviceContext) stopping() bool {
+	select {
+	case <-d.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+// running reports whether the device has been started and has not returned.
+func (d *DeviceContext) running() bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.finished == nil {
+		return false
+	}
+	select {
+	case <-d.finished:
+		return false
+	default:
+		return true
+	}
+}
+
+// reset prepares the device to be run again and marks it running, returning a
+// channel to be closed when it has returned.
+func (d *DeviceContext) reset() chan struct{} {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	select {
+	case <-d.done:
+		d.done = make(chan struct{})
+	default:
+	}
+	d.finished = make(chan struct{})
+	d.status, d.err = DeviceRunning, nil
+	return d.finished
+}
+
+// stop tells the device to stop, returning a channel that will be closed when
+// it has returned, or nil if it is not running.
+func (d *DeviceContext) stop() chan struct{} {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	select {
+	case <-d.done:
+	default:
+		close(d.done)
+	}
+	if d.finished == nil {
+		return nil
+	}
+	select {
+	case <-d.finished:
+		return nil
+	default:
+		return d.finished
+	}
 }
 
 // Type is the name of the device type intended to be instantiated.
@@ -203,9 +730,26 @@ func (d *DeviceContext) Open(name string) (sock zmq.Socket, err error) {
 	var sockContext *socketContext
 	var ok bool
 	if sockContext, ok = d.sockets[name]; !ok {
-		return nil, errors.New("no such socket.")
+		return nil, fmt.Errorf("no such socket: %s", name)
+	}
+	if sock, err = sockContext.Open(); err != nil {
+		return nil, err
 	}
-	return sockContext.Open()
+	d.mutex.Lock()
+	d.open = append(d.open, sock)
+	d.mutex.Unlock()
+	return sock, nil
+}
+
+// closeSockets closes every socket that the device opened.  gozmq ignores
+// attempts to close a socket that is already closed.
+func (d *DeviceContext) closeSockets() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for _, sock := range d.open {
+		sock.Close()
+	}
+	d.open = nil
 }
 
 // MustOpen creates and binds/connects the named socket or else panics.
@@ -230,6 +774,7 @@ type socketContext struct {
 	Int64Options  map[zmq.Int64SocketOption]int64
 	UInt64Options map[zmq.UInt64SocketOption]uint64
 	StringOptions map[zmq.StringSocketOption]string
+	Subscribe     []string
 	Bind          []string
 	Connect       []string
 }
@@ -248,6 +793,46 @@ func newSocketContext(device *DeviceContext, name string) *socketContext {
 	}
 }
 
+// setOptions copies the options given in a configuration file into the maps
+// of ØMQ socket options, leaving out any that were not given.
+func (s *socketContext) setOptions(o *SocketOptions) {
+	if o == nil {
+		return
+	}
+	if o.Hwm != 0 {
+		s.UInt64Options[zmq.HWM] = uint64(o.Hwm)
+	}
+	if o.Swap != 0 {
+		s.Int64Options[zmq.SWAP] = int64(o.Swap)
+	}
+	if o.Affinity != 0 {
+		s.UInt64Options[zmq.AFFINITY] = uint64(o.Affinity)
+	}
+	if len(o.Identity) > 0 {
+		s.StringOptions[zmq.IDENTITY] = o.Identity
+	}
+	if o.Rate != 0 {
+		s.Int64Options[zmq.RATE] = int64(o.Rate)
+	}
+	if o.RecoveryIvl != 0 {
+		s.Int64Options[zmq.RECOVERY_IVL] = int64(o.RecoveryIvl)
+	}
+	if o.McastLoop != nil {
+		if *o.McastLoop {
+			s.Int64Options[zmq.MCAST_LOOP] = 1
+		} else {
+			s.Int64Options[zmq.MCAST_LOOP] = 0
+		}
+	}
+	if o.SndBuf != 0 {
+		s.UInt64Options[zmq.SNDBUF] = uint64(o.SndBuf)
+	}
+	if o.RcvBuf != 0 {
+		s.UInt64Options[zmq.RCVBUF] = uint64(o.RcvBuf)
+	}
+	s.Subscribe = joinList(o.Subscribe, o.SubscribeAppend)
+}
+
 // Name returns the name of the socket.
 func (s *socketContext) Name() string { return s.name }
 
@@ -258,7 +843,7 @@ func (s *socketContext) Name() string { return s.name }
 func (s *socketContext) Open() (sock zmq.Socket, err error) {
 	var (
 		DeviceContext *DeviceContext
-		app           *app
+		app           *App
 	)
 	if DeviceContext = s.device; DeviceContext == nil {
 		return nil, errors.New("no device context.")
@@ -269,6 +854,7 @@ func (s *socketContext) Open() (sock zmq.Socket, err error) {
 	if sock, err = app.context.NewSocket(s.Type); err != nil {
 		return nil, errors.New(fmt.Sprintf("could not create socket: %s", err.Error()))
 	}
+	app.logf("%s: created socket %s", DeviceContext.name, s.name)
 	defer func(s zmq.Socket) {
 		if err != nil {
 			s.Close()
@@ -298,17 +884,25 @@ func (s *socketContext) Open() (sock zmq.Socket, err error) {
 				opt, val, err.Error()))
 		}
 	}
+	for _, filter := range s.Subscribe {
+		if err = sock.SetSockOptString(zmq.SUBSCRIBE, filter); err != nil {
+			return nil, errors.New(fmt.Sprintf("could not subscribe to %q : %s",
+				filter, err.Error()))
+		}
+	}
 	for _, addr := range s.Bind {
 		if err = sock.Bind(addr); err != nil {
 			return nil, errors.New(fmt.Sprintf("could not bind to %v %s",
 				addr, err.Error()))
 		}
+		app.logf("%s: bound socket %s to %s", DeviceContext.name, s.name, addr)
 	}
 	for _, addr := range s.Connect {
 		if err = sock.Connect(addr); err != nil {
 			return nil, errors.New(fmt.Sprintf("could not connect to %v %s",
 				addr, err.Error()))
 		}
+		app.logf("%s: connected socket %s to %s", DeviceContext.name, s.name, addr)
 	}
 	return
 }
@@ -6,52 +6,101 @@ package zdcf
 
 import (
 	"encoding/json"
-	"errors"
-	"fmt"
-
-	"github.com/jtacoma/go-zpl"
 )
 
+// A zdcf0 is a ZDCF 0.x document, in which devices are named alongside the
+// version and context, and sockets alongside a device's type.
 type zdcf0 struct {
-	Version float32             `version`
-	Context *context1           `context`
+	Version float32             `json:"version" zpl:"version"`
+	Context *ContextConfig      `json:"context" zpl:"context"`
 	Devices map[string]*device0 `zpl:"*"`
 }
 
 type device0 struct {
-	Type    string              `type`
-	Sockets map[string]*socket1 `zpl:"*"`
+	Type    string                   `json:"type" zpl:"type"`
+	Sockets map[string]*SocketConfig `zpl:"*"`
+}
+
+// UnmarshalJSON decodes a zdcf0, collecting every key other than version and
+// context as a device, as the zpl:"*" tag does for ZPL.
+func (z0 *zdcf0) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	for name, value := range fields {
+		var err error
+		switch name {
+		case "version":
+			err = json.Unmarshal(value, &z0.Version)
+		case "context":
+			err = json.Unmarshal(value, &z0.Context)
+		default:
+			var d0 *device0
+			if err = json.Unmarshal(value, &d0); err == nil {
+				if z0.Devices == nil {
+					z0.Devices = map[string]*device0{}
+				}
+				z0.Devices[name] = d0
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UnmarshalJSON decodes a device0, collecting every key other than type as a
+// socket.
+func (d0 *device0) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	for name, value := range fields {
+		var err error
+		if name == "type" {
+			err = json.Unmarshal(value, &d0.Type)
+		} else {
+			var s *SocketConfig
+			if err = json.Unmarshal(value, &s); err == nil {
+				if d0.Sockets == nil {
+					d0.Sockets = map[string]*SocketConfig{}
+				}
+				d0.Sockets[name] = s
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func unmarshalZdcf0(bytes []byte) (*zdcf0, error) {
 	var conf zdcf0
-	err_json := json.Unmarshal(bytes, &conf)
-	if err_json != nil {
-		err_zpl := zpl.Unmarshal(bytes, &conf)
-		if err_zpl != nil {
-			return nil, fmt.Errorf("failed to parse as JSON (%s) or as ZPL (%s).", err_json, err_zpl)
-		}
+	if err := unmarshal(bytes, &conf); err != nil {
+		return nil, err
 	}
 	if conf.Version < 0 || 1 <= conf.Version {
-		return nil, errors.New(fmt.Sprintf(
-			"unsupported ZDCF version: %f",
-			conf.Version))
+		return nil, versionError(bytes, conf.Version)
 	}
 	return &conf, nil
 }
 
-func (z0 *zdcf0) zdcf1(appName string) *zdcf1 {
-	devs := make(map[string]*device1)
+func (z0 *zdcf0) config(appName string) *Config {
+	devs := make(map[string]*DeviceConfig)
 	for name, d0 := range z0.Devices {
-		devs[name] = &device1{
+		devs[name] = &DeviceConfig{
 			Type:    d0.Type,
 			Sockets: d0.Sockets,
 		}
 	}
-	return &zdcf1{
+	return &Config{
 		Version: 1.0,
-		Apps: map[string]*app1{
-			appName: &app1{
+		Apps: map[string]*AppConfig{
+			appName: &AppConfig{
 				Context: z0.Context,
 				Devices: devs,
 			},
@@ -86,7 +86,7 @@ sub
 	if err != nil {
 		t.Fatalf("failed to unmarshal: %s", err)
 	}
-	conf := conf0.zdcf1("listener")
+	conf := conf0.config("listener")
 	if conf == nil {
 		t.Fatalf("unmarshal returned two nils.")
 	}
@@ -100,7 +100,7 @@ sub
 	if listener.Context.IoThreads != 1 {
 		t.Fatalf("listener.context.iothreads = %v", listener.Context.IoThreads)
 	}
-	if listener.Context.Verbose != true {
+	if !listener.Context.verbose() {
 		t.Fatalf("listener.context.verbose = %v", listener.Context.Verbose)
 	}
 	main, ok := listener.Devices["main"]
@@ -128,3 +128,31 @@ sub
 		t.Fatalf("backend.bind = %v", backend.Bind)
 	}
 }
+
+func TestUnmarshalZdcf0_JSON(t *testing.T) {
+	conf, err := unmarshalZdcf0([]byte(`{
+		"version": 0.1,
+		"context": {"iothreads": 1},
+		"main": {
+			"type": "zmq_queue",
+			"frontend": {"type": "SUB", "bind": ["tcp://eth0:5555"]},
+			"backend": {"connect": "tcp://eth0:5556"}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("failed to unmarshal: %s", err)
+	}
+	if conf.Context == nil || conf.Context.IoThreads != 1 {
+		t.Errorf("context = %v", conf.Context)
+	}
+	main, ok := conf.Devices["main"]
+	if !ok || main.Type != "zmq_queue" || len(conf.Devices) != 1 {
+		t.Fatalf("devices = %v", conf.Devices)
+	}
+	if frontend := main.Sockets["frontend"]; frontend == nil || frontend.Type != "SUB" {
+		t.Errorf("frontend = %v", frontend)
+	}
+	if backend := main.Sockets["backend"]; backend == nil || backend.Connect[0] != "tcp://eth0:5556" {
+		t.Errorf("backend = %v", backend)
+	}
+}
@@ -8,104 +8,410 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-
-	"github.com/jtacoma/go-zpl"
 )
 
-type zdcf1 struct {
-	Version float32          `version`
-	Apps    map[string]*app1 `apps`
+// A Config is a ZDCF 1.x document: the configuration of any number of apps,
+// each of which is a ØMQ context with a set of devices.  A *Config, built
+// directly or with NewConfig, can be used as a configuration source anywhere
+// configuration text can.
+//
+// Include lists files holding ZDCF documents to be merged before this one,
+// just as an AppConfig's Include lists files holding an app's context and
+// devices to be merged before its own.  Relative paths are resolved against
+// the directory of the file that includes them.
+type Config struct {
+	Version float32               `json:"version" zpl:"version"`
+	Include []string              `json:"include,omitempty" zpl:"include"`
+	Apps    map[string]*AppConfig `json:"apps,omitempty" zpl:"apps"`
 }
 
-type app1 struct {
-	Context *context1           `context`
-	Devices map[string]*device1 `devices`
+// An AppConfig holds the settings for an app's context and devices.
+// Templates are devices that are never created themselves but that devices
+// can extend.
+type AppConfig struct {
+	Include   []string                 `json:"include,omitempty" zpl:"include"`
+	Context   *ContextConfig           `json:"context,omitempty" zpl:"context"`
+	Templates map[string]*DeviceConfig `json:"templates,omitempty" zpl:"templates"`
+	Devices   map[string]*DeviceConfig `json:"devices,omitempty" zpl:"devices"`
 }
 
-type context1 struct {
-	IoThreads int  `iothreads`
-	Verbose   bool `verbose`
+// A ContextConfig holds the settings for an app's ØMQ context.  IoThreads is
+// honoured only if gozmq's context has a SetIOThreads method, which the
+// versions of gozmq that this package supports lack; otherwise a count other
+// than 1 is logged as a warning and ØMQ's default of 1 is used.  Linger, in
+// milliseconds, is applied to every socket the app opens; it is a pointer
+// because zero (discard pending messages on close) is a meaningful value.
+// Verbose is a pointer so that a later configuration source can turn it off.
+type ContextConfig struct {
+	IoThreads int   `json:"iothreads,omitempty" zpl:"iothreads"`
+	Verbose   *bool `json:"verbose,omitempty" zpl:"verbose"`
+	Linger    *int  `json:"linger,omitempty" zpl:"linger"`
 }
 
-type device1 struct {
-	Type    string              `type`
-	Sockets map[string]*socket1 `sockets`
+// verbose reports whether the app is to log what its devices do.
+func (c *ContextConfig) verbose() bool {
+	return c != nil && c.Verbose != nil && *c.Verbose
 }
 
-type socket1 struct {
-	Type    string    `type`
-	Options *options1 `option`
-	Bind    []string  `bind`
-	Connect []string  `connect`
+// A DeviceConfig describes a device.  A device that is null (in JSON) or
+// marked for deletion in a configuration source is removed from those before
+// it.
+//
+// Extends names a template, or another device, of the same app from which the
+// device inherits its type, sockets, restart policy and params, overriding
+// only what it sets itself as a later configuration source would.
+//
+// Params holds settings for the device itself, which are read through its
+// DeviceContext.  They are combined like devices: a later source's params are
+// merged into the earlier ones, section by section, and a null param is as
+// good as unset, even if it is set by a device that is extended.  Values from
+// ZPL are all strings.
+type DeviceConfig struct {
+	Extends string                   `json:"extends,omitempty" zpl:"extends"`
+	Type    string                   `json:"type,omitempty" zpl:"type"`
+	Sockets map[string]*SocketConfig `json:"sockets,omitempty" zpl:"sockets"`
+	Restart *RestartConfig           `json:"restart,omitempty" zpl:"restart"`
+	Params  map[string]interface{}   `json:"params,omitempty" zpl:"params"`
+	Delete  bool                     `json:"delete,omitempty" zpl:"delete"`
 }
 
-type options1 struct {
-	Hwm         int      `hwm`
-	Swap        int      `swap`
-	Affinity    int      `affinity`
-	Identity    string   `identity`
-	Subscribe   []string `subscribe`
-	Rate        int      `rate`
-	RecoveryIvl int      `recovery_ivl`
-	McastLoop   bool     `mcast_loop`
-	SndBuf      int      `sndbuf`
-	RcvBuf      int      `rcvbuf`
+// A RestartConfig says when a device should be restarted after it returns:
+// never (the default), on-failure or always.  Backoff and MaxBackoff, in
+// milliseconds, bound the delay before each restart, which doubles each time.
+// MaxRestarts is the number of restarts allowed, or zero for no limit.
+type RestartConfig struct {
+	Policy      string `json:"policy,omitempty" zpl:"policy"`
+	Backoff     int    `json:"backoff,omitempty" zpl:"backoff"`
+	MaxBackoff  int    `json:"max_backoff,omitempty" zpl:"max_backoff"`
+	MaxRestarts int    `json:"max_restarts,omitempty" zpl:"max_restarts"`
 }
 
-func unmarshalZdcf1(bytes []byte) (*zdcf1, error) {
-	var conf zdcf1
-	err_json := json.Unmarshal(bytes, &conf)
-	if err_json != nil {
-		err_zpl := zpl.Unmarshal(bytes, &conf)
-		if err_zpl != nil {
-			return nil, fmt.Errorf("failed to parse as JSON (%s) or as ZPL (%s).", err_json, err_zpl)
-		}
+// A SocketConfig describes a socket.  When configuration sources are combined,
+// a later source's bind or connect list replaces the earlier one, while a list
+// given as bind+ or connect+ is appended to it instead.  Sockets can be
+// deleted like devices.
+type SocketConfig struct {
+	Type          string         `json:"type,omitempty" zpl:"type"`
+	Options       *SocketOptions `json:"option,omitempty" zpl:"option"`
+	Bind          []string       `json:"bind,omitempty" zpl:"bind"`
+	BindAppend    []string       `json:"bind+,omitempty" zpl:"bind+"`
+	Connect       []string       `json:"connect,omitempty" zpl:"connect"`
+	ConnectAppend []string       `json:"connect+,omitempty" zpl:"connect+"`
+	Delete        bool           `json:"delete,omitempty" zpl:"delete"`
+}
+
+// A SocketOptions holds the socket options named by the ZDCF spec.
+//
+// A zero value means the option was not given and ØMQ's default applies, which
+// is why McastLoop (whose default is true) is a pointer.  Subscribe and
+// SubscribeAppend are combined like a SocketConfig's bind lists.
+type SocketOptions struct {
+	Hwm             int      `json:"hwm,omitempty" zpl:"hwm"`
+	Swap            int      `json:"swap,omitempty" zpl:"swap"`
+	Affinity        int      `json:"affinity,omitempty" zpl:"affinity"`
+	Identity        string   `json:"identity,omitempty" zpl:"identity"`
+	Subscribe       []string `json:"subscribe,omitempty" zpl:"subscribe"`
+	SubscribeAppend []string `json:"subscribe+,omitempty" zpl:"subscribe+"`
+	Rate            int      `json:"rate,omitempty" zpl:"rate"`
+	RecoveryIvl     int      `json:"recovery_ivl,omitempty" zpl:"recovery_ivl"`
+	McastLoop       *bool    `json:"mcast_loop,omitempty" zpl:"mcast_loop"`
+	SndBuf          int      `json:"sndbuf,omitempty" zpl:"sndbuf"`
+	RcvBuf          int      `json:"rcvbuf,omitempty" zpl:"rcvbuf"`
+}
+
+func unmarshalZdcf1(bytes []byte) (*Config, error) {
+	var conf Config
+	if err := unmarshal(bytes, &conf); err != nil {
+		return nil, err
 	}
 	if conf.Version < 1 || 2 <= conf.Version {
-		return nil, errors.New(fmt.Sprintf(
-			"unsupported ZDCF version: %f",
-			conf.Version))
+		return nil, versionError(bytes, conf.Version)
 	}
 	return &conf, nil
 }
 
-func (conf *zdcf1) update(other *zdcf1) error {
+// A jsonList is a list of strings that may also be given in JSON as a single
+// string, as a ZPL value that is not repeated would be.  An empty list is
+// decoded as nil, which is how it is written: not at all.
+type jsonList []string
+
+func (l *jsonList) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var item string
+		if err := json.Unmarshal(data, &item); err != nil {
+			return err
+		}
+		*l = jsonList{item}
+		return nil
+	}
+	if err := json.Unmarshal(data, (*[]string)(l)); err != nil {
+		return err
+	}
+	if len(*l) == 0 {
+		*l = nil
+	}
+	return nil
+}
+
+// UnmarshalJSON decodes a Config, accepting a single path in place of a list
+// for include.
+func (c *Config) UnmarshalJSON(data []byte) error {
+	type plain Config
+	lists := struct {
+		*plain
+		Include jsonList `json:"include"`
+	}{plain: (*plain)(c)}
+	if err := json.Unmarshal(data, &lists); err != nil {
+		return err
+	}
+	c.Include = lists.Include
+	return nil
+}
+
+// UnmarshalJSON decodes an AppConfig, accepting a single path in place of a
+// list for include.
+func (a *AppConfig) UnmarshalJSON(data []byte) error {
+	type plain AppConfig
+	lists := struct {
+		*plain
+		Include jsonList `json:"include"`
+	}{plain: (*plain)(a)}
+	if err := json.Unmarshal(data, &lists); err != nil {
+		return err
+	}
+	a.Include = lists.Include
+	return nil
+}
+
+// UnmarshalJSON decodes a SocketConfig, accepting a single endpoint in place
+// of a list for bind and connect.
+func (s *SocketConfig) UnmarshalJSON(data []byte) error {
+	type plain SocketConfig
+	lists := struct {
+		*plain
+		Bind          jsonList `json:"bind"`
+		BindAppend    jsonList `json:"bind+"`
+		Connect       jsonList `json:"connect"`
+		ConnectAppend jsonList `json:"connect+"`
+	}{plain: (*plain)(s)}
+	if err := json.Unmarshal(data, &lists); err != nil {
+		return err
+	}
+	s.Bind, s.BindAppend = lists.Bind, lists.BindAppend
+	s.Connect, s.ConnectAppend = lists.Connect, lists.ConnectAppend
+	return nil
+}
+
+// UnmarshalJSON decodes a SocketOptions, accepting a single filter in place
+// of a list for subscribe.
+func (o *SocketOptions) UnmarshalJSON(data []byte) error {
+	type plain SocketOptions
+	lists := struct {
+		*plain
+		Subscribe       jsonList `json:"subscribe"`
+		SubscribeAppend jsonList `json:"subscribe+"`
+	}{plain: (*plain)(o)}
+	if err := json.Unmarshal(data, &lists); err != nil {
+		return err
+	}
+	o.Subscribe, o.SubscribeAppend = lists.Subscribe, lists.SubscribeAppend
+	return nil
+}
+
+// update merges other into c and returns the result, which is a new context
+// if c was nil.  Verbose and linger are taken from other whenever it sets
+// them, so an overlay can turn verbose off as well as on.
+func (c *ContextConfig) update(other *ContextConfig) *ContextConfig {
+	if other == nil {
+		return c
+	}
+	if c == nil {
+		c = &ContextConfig{}
+	}
+	if other.IoThreads != 0 {
+		c.IoThreads = other.IoThreads
+	}
+	if other.Verbose != nil {
+		c.Verbose = other.Verbose
+	}
+	if other.Linger != nil {
+		c.Linger = other.Linger
+	}
+	return c
+}
+
+// update merges other into r and returns the result, which is a new restart
+// policy if r was nil.
+func (r *RestartConfig) update(other *RestartConfig) *RestartConfig {
+	if other == nil {
+		return r
+	}
+	if r == nil {
+		r = &RestartConfig{}
+	}
+	if len(other.Policy) > 0 {
+		r.Policy = other.Policy
+	}
+	if other.Backoff != 0 {
+		r.Backoff = other.Backoff
+	}
+	if other.MaxBackoff != 0 {
+		r.MaxBackoff = other.MaxBackoff
+	}
+	if other.MaxRestarts != 0 {
+		r.MaxRestarts = other.MaxRestarts
+	}
+	return r
+}
+
+// update merges other into o and returns the result, which is a new set of
+// options if o was nil.  Only the options given in other are changed.
+func (o *SocketOptions) update(other *SocketOptions) *SocketOptions {
+	if other == nil {
+		return o
+	}
+	if o == nil {
+		o = &SocketOptions{}
+	}
+	if other.Hwm != 0 {
+		o.Hwm = other.Hwm
+	}
+	if other.Swap != 0 {
+		o.Swap = other.Swap
+	}
+	if other.Affinity != 0 {
+		o.Affinity = other.Affinity
+	}
+	if len(other.Identity) > 0 {
+		o.Identity = other.Identity
+	}
+	o.Subscribe, o.SubscribeAppend = updateList(
+		o.Subscribe, o.SubscribeAppend, other.Subscribe, other.SubscribeAppend)
+	if other.Rate != 0 {
+		o.Rate = other.Rate
+	}
+	if other.RecoveryIvl != 0 {
+		o.RecoveryIvl = other.RecoveryIvl
+	}
+	if other.McastLoop != nil {
+		o.McastLoop = other.McastLoop
+	}
+	if other.SndBuf != 0 {
+		o.SndBuf = other.SndBuf
+	}
+	if other.RcvBuf != 0 {
+		o.RcvBuf = other.RcvBuf
+	}
+	return o
+}
+
+// updateList merges a list and the items to be appended to it with those of a
+// later source.  A non-empty list in the later source replaces both.
+func updateList(list, appended, otherList, otherAppended []string) ([]string, []string) {
+	if len(otherList) > 0 {
+		list, appended = otherList, nil
+	}
+	return list, append(appended, otherAppended...)
+}
+
+// joinList returns a new slice holding a list followed by the items appended
+// to it.
+func joinList(list, appended []string) []string {
+	return append(append([]string(nil), list...), appended...)
+}
+
+func (conf *Config) update(other *Config) error {
 	if other.Version < 1 || 2 <= other.Version {
 		return errors.New(fmt.Sprintf(
 			"unsupported ZDCF version: %f",
 			other.Version))
 	}
+	if conf.Apps == nil {
+		conf.Apps = map[string]*AppConfig{}
+	}
 	for appName, appConf := range other.Apps {
-		if appConf0, already := conf.Apps[appName]; !already {
-			conf.Apps[appName] = appConf
-		} else {
-			// TODO: context? gozmq provides no API for this.
-			for devName, devConf := range appConf.Devices {
-				if devConf0, already := appConf0.Devices[devName]; !already {
-					appConf0.Devices[devName] = devConf
-				} else {
-					for sockName, sockConf := range devConf.Sockets {
-						if sockConf0, already := devConf0.Sockets[sockName]; !already {
-							devConf0.Sockets[sockName] = sockConf
-						} else {
-							if len(sockConf.Type) > 0 {
-								sockConf0.Type = sockConf.Type
-							}
-							if sockConf.Options != nil {
-								// TODO: do a proper update here!
-								sockConf0.Options = sockConf.Options
-							}
-							if len(sockConf.Bind) > 0 {
-								sockConf0.Bind = sockConf.Bind
-							}
-							if len(sockConf.Connect) > 0 {
-								sockConf0.Connect = sockConf.Connect
-							}
-						}
-					}
-				}
-			}
+		if appConf == nil {
+			continue
+		}
+		appConf0, already := conf.Apps[appName]
+		if !already {
+			appConf0 = &AppConfig{}
+			conf.Apps[appName] = appConf0
 		}
+		appConf0.update(appConf)
 	}
 	return nil
 }
+
+// update merges other into a.  Devices and templates in other that are null
+// or marked for deletion are removed from a.
+func (a *AppConfig) update(other *AppConfig) {
+	a.Context = a.Context.update(other.Context)
+	if len(other.Templates) > 0 {
+		a.Templates = updateDevices(a.Templates, other.Templates)
+	}
+	a.Devices = updateDevices(a.Devices, other.Devices)
+}
+
+// updateDevices merges the devices in other into those in devices and
+// returns the result, which is a new map if devices was nil.
+func updateDevices(devices, other map[string]*DeviceConfig) map[string]*DeviceConfig {
+	if devices == nil {
+		devices = map[string]*DeviceConfig{}
+	}
+	for devName, devConf := range other {
+		if devConf == nil || devConf.Delete {
+			delete(devices, devName)
+			continue
+		}
+		devConf0, already := devices[devName]
+		if !already {
+			devConf0 = &DeviceConfig{}
+			devices[devName] = devConf0
+		}
+		devConf0.update(devConf)
+	}
+	return devices
+}
+
+// update merges other into d.  Sockets in other that are null or marked for
+// deletion are removed from d.
+func (d *DeviceConfig) update(other *DeviceConfig) {
+	if len(other.Extends) > 0 {
+		d.Extends = other.Extends
+	}
+	if len(other.Type) > 0 {
+		d.Type = other.Type
+	}
+	d.Restart = d.Restart.update(other.Restart)
+	if len(other.Params) > 0 {
+		d.Params = updateParams(d.Params, other.Params)
+	}
+	if d.Sockets == nil {
+		d.Sockets = map[string]*SocketConfig{}
+	}
+	for sockName, sockConf := range other.Sockets {
+		if sockConf == nil || sockConf.Delete {
+			delete(d.Sockets, sockName)
+			continue
+		}
+		sockConf0, already := d.Sockets[sockName]
+		if !already {
+			sockConf0 = &SocketConfig{}
+			d.Sockets[sockName] = sockConf0
+		}
+		sockConf0.update(sockConf)
+	}
+}
+
+// update merges other into s.
+func (s *SocketConfig) update(other *SocketConfig) {
+	if len(other.Type) > 0 {
+		s.Type = other.Type
+	}
+	s.Options = s.Options.update(other.Options)
+	s.Bind, s.BindAppend = updateList(
+		s.Bind, s.BindAppend, other.Bind, other.BindAppend)
+	s.Connect, s.ConnectAppend = updateList(
+		s.Connect, s.ConnectAppend, other.Connect, other.ConnectAppend)
+}
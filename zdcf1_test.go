@@ -5,6 +5,8 @@
 package zdcf
 
 import (
+	"fmt"
+	"reflect"
 	"testing"
 )
 
@@ -55,7 +57,7 @@ func TestUnmarshalZdcf1_JSON(t *testing.T) {
 	if listener.Context.IoThreads != 1 {
 		t.Fatalf("listener.context.iothreads = %v", listener.Context.IoThreads)
 	}
-	if listener.Context.Verbose != true {
+	if !listener.Context.verbose() {
 		t.Fatalf("listener.context.verbose = %v", listener.Context.Verbose)
 	}
 	main, ok := listener.Devices["main"]
@@ -121,7 +123,7 @@ apps
 	if listener.Context.IoThreads != 1 {
 		t.Fatalf("listener.context.iothreads = %v", listener.Context.IoThreads)
 	}
-	if listener.Context.Verbose != true {
+	if !listener.Context.verbose() {
 		t.Fatalf("listener.context.verbose = %v", listener.Context.Verbose)
 	}
 	main, ok := listener.Devices["main"]
@@ -150,22 +152,131 @@ apps
 	}
 }
 
+func TestUnmarshalZdcf1_JSONAndZPL(t *testing.T) {
+	asJSON := `{
+		"version": 1.0,
+		"apps": {
+			"listener": {
+				"context": {"iothreads": 2, "linger": 0},
+				"devices": {
+					"main": {
+						"type": "zmq_forwarder",
+						"restart": {"policy": "always", "max_restarts": 3},
+						"sockets": {
+							"frontend": {
+								"type": "SUB",
+								"option": {
+									"hwm": 1000,
+									"mcast_loop": false,
+									"subscribe": "weather",
+									"subscribe+": ["news", "sports"]
+								},
+								"bind": "tcp://eth0:5555",
+								"bind+": ["tcp://eth1:5555", "ipc:///tmp/frontend"]
+							},
+							"backend": {
+								"type": "PUB",
+								"connect": ["tcp://eth0:5556"],
+								"connect+": "tcp://eth1:5556"
+							}
+						}
+					}
+				}
+			}
+		}
+	}`
+	asZPL := `
+version = 1.0
+apps
+    listener
+        context
+            iothreads = 2
+            linger = 0
+        devices
+            main
+                type = zmq_forwarder
+                restart
+                    policy = always
+                    max_restarts = 3
+                sockets
+                    frontend
+                        type = SUB
+                        option
+                            hwm = 1000
+                            mcast_loop = false
+                            subscribe = weather
+                            subscribe+ = news
+                            subscribe+ = sports
+                        bind = tcp://eth0:5555
+                        bind+ = tcp://eth1:5555
+                        bind+ = ipc:///tmp/frontend
+                    backend
+                        type = PUB
+                        connect = tcp://eth0:5556
+                        connect+ = tcp://eth1:5556`
+	fromJSON, err := unmarshalZdcf1([]byte(asJSON))
+	if err != nil {
+		t.Fatalf("failed to unmarshal JSON: %s", err)
+	}
+	fromZPL, err := unmarshalZdcf1([]byte(asZPL))
+	if err != nil {
+		t.Fatalf("failed to unmarshal ZPL: %s", err)
+	}
+	if !reflect.DeepEqual(fromJSON, fromZPL) {
+		t.Errorf("JSON and ZPL differ:\n%#v\n%#v", fromJSON, fromZPL)
+	}
+	frontend := fromJSON.Apps["listener"].Devices["main"].Sockets["frontend"]
+	if len(frontend.Bind) != 1 || frontend.Bind[0] != "tcp://eth0:5555" {
+		t.Errorf("frontend.Bind = %v", frontend.Bind)
+	}
+	if subscribe := frontend.Options.Subscribe; len(subscribe) != 1 || subscribe[0] != "weather" {
+		t.Errorf("frontend.Options.Subscribe = %v", subscribe)
+	}
+}
+
+func TestUnmarshalZdcf1_JSONListErrors(t *testing.T) {
+	_, err := unmarshalZdcf1([]byte(`{
+		"version": 1.0,
+		"apps": {
+			"listener": {
+				"devices": {
+					"main": {
+						"sockets": {
+							"frontend": {
+								"bind": ["tcp://eth0:5555", 5555]
+							}
+						}
+					}
+				}
+			}
+		}
+	}`))
+	parseErr, ok := err.(*ParseError)
+	if !ok {
+		t.Fatalf("err = %v", err)
+	}
+	if parseErr.Line != 9 || parseErr.Key != "apps/listener/devices/main/sockets/frontend/bind/1" {
+		t.Errorf("err = %s", err)
+	}
+}
+
 func TestZdcf1_update(t *testing.T) {
-	var conf = &zdcf1{
+	yes := true
+	var conf = &Config{
 		Version: 1.0,
-		Apps: map[string]*app1{
-			"listener": &app1{
-				Context: &context1{
+		Apps: map[string]*AppConfig{
+			"listener": &AppConfig{
+				Context: &ContextConfig{
 					IoThreads: 1,
-					Verbose:   true,
+					Verbose:   &yes,
 				},
-				Devices: map[string]*device1{
-					"main": &device1{
+				Devices: map[string]*DeviceConfig{
+					"main": &DeviceConfig{
 						Type: "zmq_queue",
-						Sockets: map[string]*socket1{
-							"frontend": &socket1{
+						Sockets: map[string]*SocketConfig{
+							"frontend": &SocketConfig{
 								Type: "SUB",
-								Options: &options1{
+								Options: &SocketOptions{
 									Hwm:       1000,
 									Swap:      25000000,
 									Subscribe: []string{"4321 "},
@@ -178,20 +289,20 @@ func TestZdcf1_update(t *testing.T) {
 			},
 		},
 	}
-	conf.update(&zdcf1{
+	conf.update(&Config{
 		Version: 1.0,
-		Apps: map[string]*app1{
-			"listener": &app1{
-				Context: &context1{
+		Apps: map[string]*AppConfig{
+			"listener": &AppConfig{
+				Context: &ContextConfig{
 					IoThreads: 1,
-					Verbose:   true,
+					Verbose:   &yes,
 				},
-				Devices: map[string]*device1{
-					"main": &device1{
+				Devices: map[string]*DeviceConfig{
+					"main": &DeviceConfig{
 						Type: "zmq_queue",
-						Sockets: map[string]*socket1{
-							"frontend": &socket1{
-								Options: &options1{
+						Sockets: map[string]*SocketConfig{
+							"frontend": &SocketConfig{
+								Options: &SocketOptions{
 									Subscribe: []string{
 										"1234 ",
 										"1235 ",
@@ -199,14 +310,14 @@ func TestZdcf1_update(t *testing.T) {
 								},
 								Bind: []string{"tcp://eth0:5555"},
 							},
-							"backend": &socket1{
+							"backend": &SocketConfig{
 								Connect: []string{"tcp://eth0:5556"},
 							},
 						},
 					},
 				},
 			},
-			"speaker": &app1{},
+			"speaker": &AppConfig{},
 		},
 	})
 	listener, ok := conf.Apps["listener"]
@@ -216,7 +327,7 @@ func TestZdcf1_update(t *testing.T) {
 	if listener.Context.IoThreads != 1 {
 		t.Fatalf("listener.context.iothreads = %v", listener.Context.IoThreads)
 	}
-	if listener.Context.Verbose != true {
+	if !listener.Context.verbose() {
 		t.Fatalf("listener.context.verbose = %v", listener.Context.Verbose)
 	}
 	main, ok := listener.Devices["main"]
@@ -248,3 +359,210 @@ func TestZdcf1_update(t *testing.T) {
 		t.Errorf("apps does not contain %v", "speaker")
 	}
 }
+
+func TestZdcf1_update_Context(t *testing.T) {
+	yes, no := true, false
+	var conf = &Config{
+		Version: 1.0,
+		Apps: map[string]*AppConfig{
+			"listener": &AppConfig{
+				Devices: map[string]*DeviceConfig{},
+			},
+			"speaker": &AppConfig{
+				Context: &ContextConfig{
+					IoThreads: 2,
+				},
+				Devices: map[string]*DeviceConfig{},
+			},
+		},
+	}
+	conf.update(&Config{
+		Version: 1.0,
+		Apps: map[string]*AppConfig{
+			"listener": &AppConfig{
+				Context: &ContextConfig{
+					IoThreads: 3,
+				},
+			},
+			"speaker": &AppConfig{
+				Context: &ContextConfig{
+					Verbose: &yes,
+				},
+			},
+		},
+	})
+	listener := conf.Apps["listener"]
+	if listener.Context == nil || listener.Context.IoThreads != 3 {
+		t.Fatalf("listener.context = %v", listener.Context)
+	}
+	speaker := conf.Apps["speaker"]
+	if speaker.Context.IoThreads != 2 {
+		t.Errorf("speaker.context.iothreads = %v", speaker.Context.IoThreads)
+	}
+	if !speaker.Context.verbose() {
+		t.Errorf("speaker.context.verbose = %v", speaker.Context.Verbose)
+	}
+	conf.update(&Config{
+		Version: 1.0,
+		Apps: map[string]*AppConfig{
+			"speaker": &AppConfig{
+				Context: &ContextConfig{
+					Verbose: &no,
+				},
+			},
+		},
+	})
+	if speaker.Context.verbose() || speaker.Context.IoThreads != 2 {
+		t.Errorf("speaker.context = %+v", speaker.Context)
+	}
+}
+
+func TestZdcf1_update_Merge(t *testing.T) {
+	conf, err := unmarshalZdcf1([]byte(`
+version = 1.0
+apps
+    listener
+        devices
+            main
+                type = zmq_queue
+                sockets
+                    frontend
+                        type = SUB
+                        option
+                            hwm = 1000
+                            subscribe = A
+                        bind = tcp://eth0:5555
+                        connect = tcp://eth0:5556
+                    backend
+                        type = PUSH
+                        bind = tcp://eth0:5557`))
+	if err != nil {
+		t.Fatalf("failed to unmarshal: %s", err)
+	}
+	overlay, err := unmarshalZdcf1([]byte(`{
+		"version": 1.0,
+		"apps": {
+			"listener": {
+				"devices": {
+					"main": {
+						"type": "zmq_forwarder",
+						"sockets": {
+							"frontend": {
+								"option": {
+									"rate": 200,
+									"subscribe+": ["B"]
+								},
+								"bind+": ["tcp://eth1:5555"],
+								"connect": ["tcp://eth1:5556"]
+							},
+							"backend": {
+								"bind": ["tcp://eth1:5557"],
+								"bind+": ["tcp://eth2:5557"]
+							}
+						}
+					}
+				}
+			}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("failed to unmarshal: %s", err)
+	}
+	if err = conf.update(overlay); err != nil {
+		t.Fatalf("failed to update: %s", err)
+	}
+	main := conf.Apps["listener"].Devices["main"]
+	if main.Type != "zmq_forwarder" {
+		t.Errorf("main.type = %v", main.Type)
+	}
+	frontend := main.Sockets["frontend"]
+	if frontend.Type != "SUB" {
+		t.Errorf("frontend.type = %v", frontend.Type)
+	}
+	if frontend.Options.Hwm != 1000 {
+		t.Errorf("frontend.option.hwm = %v", frontend.Options.Hwm)
+	}
+	if frontend.Options.Rate != 200 {
+		t.Errorf("frontend.option.rate = %v", frontend.Options.Rate)
+	}
+	if subscribe := joinList(frontend.Options.Subscribe, frontend.Options.SubscribeAppend); fmt.Sprint(subscribe) != "[A B]" {
+		t.Errorf("frontend.option.subscribe = %v", subscribe)
+	}
+	if bind := joinList(frontend.Bind, frontend.BindAppend); fmt.Sprint(bind) != "[tcp://eth0:5555 tcp://eth1:5555]" {
+		t.Errorf("frontend.bind = %v", bind)
+	}
+	if connect := joinList(frontend.Connect, frontend.ConnectAppend); fmt.Sprint(connect) != "[tcp://eth1:5556]" {
+		t.Errorf("frontend.connect = %v", connect)
+	}
+	backend := main.Sockets["backend"]
+	if bind := joinList(backend.Bind, backend.BindAppend); fmt.Sprint(bind) != "[tcp://eth1:5557 tcp://eth2:5557]" {
+		t.Errorf("backend.bind = %v", bind)
+	}
+}
+
+func TestZdcf1_update_Delete(t *testing.T) {
+	var conf = &Config{Version: 1.0}
+	for _, raw := range []string{`
+version = 1.0
+apps
+    listener
+        devices
+            main
+                type = zmq_queue
+                sockets
+                    frontend
+                        type = ROUTER
+                    backend
+                        type = DEALER
+                    monitor
+                        type = PUB
+            debug
+                type = zmq_forwarder
+            trace
+                type = zmq_forwarder
+            never
+                delete = true`, `{
+		"version": 1.0,
+		"apps": {
+			"listener": {
+				"devices": {
+					"main": {
+						"sockets": {
+							"monitor": null
+						}
+					},
+					"debug": null
+				}
+			}
+		}
+	}`, `
+version = 1.0
+apps
+    listener
+        devices
+            main
+                sockets
+                    backend
+                        delete = true
+            trace
+                delete = true`} {
+		next, err := unmarshalZdcf1([]byte(raw))
+		if err != nil {
+			t.Fatalf("failed to unmarshal: %s", err)
+		}
+		if err = conf.update(next); err != nil {
+			t.Fatalf("failed to update: %s", err)
+		}
+	}
+	listener := conf.Apps["listener"]
+	if len(listener.Devices) != 1 {
+		t.Fatalf("listener.devices = %v", listener.Devices)
+	}
+	main, ok := listener.Devices["main"]
+	if !ok {
+		t.Fatalf("listener.devices does not contain %v", "main")
+	}
+	if len(main.Sockets) != 1 || main.Sockets["frontend"] == nil {
+		t.Errorf("main.sockets = %v", main.Sockets)
+	}
+}
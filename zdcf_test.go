@@ -5,9 +5,19 @@
 package zdcf
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
+
+	zmq "github.com/alecthomas/gozmq"
 )
 
 func TestZdcf(t *testing.T) {
@@ -94,6 +104,786 @@ func TestZdcf(t *testing.T) {
 	}
 }
 
+func TestSocketOptions(t *testing.T) {
+	conf := `{
+		"version": 1.0,
+		"apps": {
+			"options": {
+				"devices": {
+					"main": {
+						"type": "test_options",
+						"sockets": {
+							"sub": {
+								"type": "SUB",
+								"option": {
+									"hwm": 1000,
+									"swap": 25000000,
+									"affinity": 1,
+									"identity": "main/sub",
+									"subscribe": ["A", "B"],
+									"rate": 200,
+									"recovery_ivl": 20,
+									"mcast_loop": false,
+									"sndbuf": 4096,
+									"rcvbuf": 8192
+								},
+								"connect": ["tcp://127.0.0.1:5558"]
+							}
+						}
+					}
+				}
+			}
+		}
+	}`
+	app, err := NewApp("options", conf)
+	if err != nil {
+		t.Fatalf("failed to create app: %s", err)
+	}
+	defer app.Close()
+	dev, ok := app.devices["main"]
+	if !ok {
+		t.Fatalf("app.devices does not contain %v", "main")
+	}
+	if subscribe := dev.sockets["sub"].Subscribe; len(subscribe) != 2 || subscribe[0] != "A" || subscribe[1] != "B" {
+		t.Errorf("sub.subscribe = %v", subscribe)
+	}
+	sock, err := dev.Open("sub")
+	if err != nil {
+		t.Fatalf("failed to open socket: %s", err)
+	}
+	defer sock.Close()
+	if hwm, err := sock.GetSockOptUInt64(zmq.HWM); err != nil || hwm != 1000 {
+		t.Errorf("hwm = %v (%v)", hwm, err)
+	}
+	if swap, err := sock.GetSockOptInt64(zmq.SWAP); err != nil || swap != 25000000 {
+		t.Errorf("swap = %v (%v)", swap, err)
+	}
+	if affinity, err := sock.GetSockOptUInt64(zmq.AFFINITY); err != nil || affinity != 1 {
+		t.Errorf("affinity = %v (%v)", affinity, err)
+	}
+	if identity, err := sock.GetSockOptString(zmq.IDENTITY); err != nil || identity != "main/sub" {
+		t.Errorf("identity = %v (%v)", identity, err)
+	}
+	if rate, err := sock.GetSockOptInt64(zmq.RATE); err != nil || rate != 200 {
+		t.Errorf("rate = %v (%v)", rate, err)
+	}
+	if ivl, err := sock.GetSockOptInt64(zmq.RECOVERY_IVL); err != nil || ivl != 20 {
+		t.Errorf("recovery_ivl = %v (%v)", ivl, err)
+	}
+	if loop, err := sock.GetSockOptInt64(zmq.MCAST_LOOP); err != nil || loop != 0 {
+		t.Errorf("mcast_loop = %v (%v)", loop, err)
+	}
+	if sndbuf, err := sock.GetSockOptUInt64(zmq.SNDBUF); err != nil || sndbuf != 4096 {
+		t.Errorf("sndbuf = %v (%v)", sndbuf, err)
+	}
+	if rcvbuf, err := sock.GetSockOptUInt64(zmq.RCVBUF); err != nil || rcvbuf != 8192 {
+		t.Errorf("rcvbuf = %v (%v)", rcvbuf, err)
+	}
+}
+
+func TestSocketOptions_Subscribe(t *testing.T) {
+	conf := `
+version = 1.0
+apps
+    filter
+        devices
+            main
+                type = test_subscribe
+                sockets
+                    pub
+                        type = PUB
+                        bind = tcp://127.0.0.1:5559
+                    sub
+                        type = SUB
+                        option
+                            subscribe = B
+                            subscribe = C
+                        connect = tcp://127.0.0.1:5559`
+	app, err := NewApp("filter", conf)
+	if err != nil {
+		t.Fatalf("failed to create app: %s", err)
+	}
+	defer app.Close()
+	dev := app.devices["main"]
+	pub := dev.MustOpen("pub")
+	defer pub.Close()
+	sub := dev.MustOpen("sub")
+	defer sub.Close()
+	time.Sleep(100 * time.Millisecond)
+	for _, msg := range []string{"A1", "B2", "C3"} {
+		if err = pub.Send([]byte(msg), 0); err != nil {
+			t.Fatalf("failed to send: %s", err)
+		}
+	}
+	for _, expected := range []string{"B2", "C3"} {
+		msg, err := sub.Recv(0)
+		if err != nil {
+			t.Fatalf("failed to receive: %s", err)
+		}
+		if string(msg) != expected {
+			t.Errorf("received %q, expected %q", msg, expected)
+		}
+	}
+}
+
+func TestContextOptions(t *testing.T) {
+	conf := `
+version = 1.0
+apps
+    chatty
+        context
+            verbose = true
+        devices
+            main
+                type = test_verbose
+                sockets
+                    out
+                        type = PUSH
+                        bind = tcp://127.0.0.1:5560`
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	app, err := NewApp("chatty", conf)
+	if err != nil {
+		t.Fatalf("failed to create app: %s", err)
+	}
+	defer app.Close()
+	out := app.devices["main"].MustOpen("out")
+	defer out.Close()
+	for _, expected := range []string{
+		"zdcf: chatty: main: created socket out",
+		"zdcf: chatty: main: bound socket out to tcp://127.0.0.1:5560",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("log does not contain %q: %s", expected, buf.String())
+		}
+	}
+}
+
+// threadedContext is a ØMQ context that can set its number of I/O threads.
+type threadedContext struct {
+	zmq.Context
+	threads int
+}
+
+func (c *threadedContext) SetIOThreads(n int) error {
+	c.threads = n
+	return nil
+}
+
+// plainContext is a ØMQ context that cannot, as with the gozmq this package
+// builds against.
+type plainContext struct {
+	zmq.Context
+}
+
+func TestContextOptions_IOThreads(t *testing.T) {
+	conf := `
+version = 1.0
+apps
+    threaded
+        context
+            iothreads = 2`
+	defer func() { zmqNewContext = zmq.NewContext }()
+	warning := "zdcf: threaded: warning: this version of gozmq cannot set iothreads = 2; using 1."
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	threaded := &threadedContext{}
+	zmqNewContext = func() (zmq.Context, error) {
+		context, err := zmq.NewContext()
+		threaded.Context = context
+		return threaded, err
+	}
+	app, err := NewApp("threaded", conf)
+	if err != nil {
+		t.Fatalf("failed to create app: %s", err)
+	}
+	app.Close()
+	if threaded.threads != 2 {
+		t.Errorf("iothreads = %d", threaded.threads)
+	}
+	if strings.Contains(buf.String(), warning) {
+		t.Errorf("warned although iothreads was set: %s", buf.String())
+	}
+	zmqNewContext = func() (zmq.Context, error) {
+		context, err := zmq.NewContext()
+		return plainContext{context}, err
+	}
+	if app, err = NewApp("threaded", conf); err != nil {
+		t.Fatalf("failed to create app without SetIOThreads: %s", err)
+	}
+	app.Close()
+	if !strings.Contains(buf.String(), warning) {
+		t.Errorf("log does not contain %q: %s", warning, buf.String())
+	}
+}
+
+func TestListenAndServeContext(t *testing.T) {
+	conf := `
+version = 1.0
+apps
+    stoppable
+        context
+            linger = 0
+        devices
+            main
+                type = zmq_streamer
+                sockets
+                    frontend
+                        type = PULL
+                        bind = tcp://127.0.0.1:5561
+                    backend
+                        type = PUSH
+                        bind = tcp://127.0.0.1:5562
+            blocked
+                type = test_block
+                sockets
+                    in
+                        type = PULL
+                        connect = tcp://127.0.0.1:5562
+            watcher
+                type = test_watch
+                sockets
+                    out
+                        type = PUSH
+                        connect = tcp://127.0.0.1:5561`
+	var watched = make(chan bool, 1)
+	DeviceFunc("test_block", func(ctx *DeviceContext) {
+		in := ctx.MustOpen("in")
+		in.Recv(0)
+	})
+	DeviceFunc("test_watch", func(ctx *DeviceContext) {
+		ctx.MustOpen("out")
+		<-ctx.Done()
+		watched <- true
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() {
+		result <- ListenAndServeContext(ctx, "stoppable", conf)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-result:
+		if err != context.Canceled {
+			t.Errorf("err = %v", err)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatalf("timed out :-(")
+	}
+	select {
+	case <-watched:
+	default:
+		t.Errorf("test_watch did not see Done()")
+	}
+}
+
+func TestListenAndServe_DeviceErrors(t *testing.T) {
+	conf := `
+version = 1.0
+apps
+    failing
+        devices
+            ok
+                type = test_ok
+            error
+                type = test_error
+            panic
+                type = test_panic`
+	DeviceFunc("test_ok", func(ctx *DeviceContext) {})
+	DeviceErrFunc("test_error", func(ctx *DeviceContext) error {
+		return errors.New("broken")
+	})
+	DeviceFunc("test_panic", func(ctx *DeviceContext) {
+		ctx.MustOpen("nothing")
+	})
+	missing := conf + `
+            missing
+                type = zmq_queue
+                sockets
+                    frontend
+                        type = ROUTER`
+	err := ListenAndServe("failing", missing)
+	if _, ok := err.(DeviceErrors); ok || err == nil ||
+		!strings.Contains(err.Error(), "apps/failing/devices/missing/sockets: missing socket backend, which zmq_queue devices require.") {
+		t.Errorf("err = %v", err)
+	}
+	err = ListenAndServe("failing", conf)
+	failed, ok := err.(DeviceErrors)
+	if !ok {
+		t.Fatalf("err = %v", err)
+	}
+	messages := map[string]string{}
+	for _, e := range failed {
+		messages[e.Device] = e.Err.Error()
+	}
+	expected := map[string]string{
+		"error": "broken",
+		"panic": "panic: no such socket: nothing",
+	}
+	if len(messages) != len(expected) {
+		t.Errorf("failed devices = %v", messages)
+	}
+	for name, message := range expected {
+		if messages[name] != message {
+			t.Errorf("device %s: %q, expected %q", name, messages[name], message)
+		}
+	}
+}
+
+func TestListenAndServe_Restart(t *testing.T) {
+	conf := `
+version = 1.0
+apps
+    supervised
+        devices
+            flaky
+                type = test_flaky
+                restart
+                    policy = on-failure
+                    backoff = 1
+                sockets
+                    out
+                        type = PUSH
+                        bind = tcp://127.0.0.1:5563
+            repeat
+                type = test_repeat
+                restart
+                    policy = always
+                    backoff = 1
+                    max_restarts = 2
+            broken
+                type = test_broken
+                restart
+                    policy = on-failure
+                    backoff = 1
+                    max_backoff = 2
+                    max_restarts = 3`
+	var runs = map[string]int{}
+	var mutex sync.Mutex
+	count := func(ctx *DeviceContext) int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		runs[ctx.Type()]++
+		return runs[ctx.Type()]
+	}
+	DeviceErrFunc("test_flaky", func(ctx *DeviceContext) error {
+		ctx.MustOpen("out")
+		if count(ctx) < 3 {
+			return errors.New("flaky")
+		}
+		return nil
+	})
+	DeviceFunc("test_repeat", func(ctx *DeviceContext) {
+		count(ctx)
+	})
+	DeviceErrFunc("test_broken", func(ctx *DeviceContext) error {
+		return fmt.Errorf("broken %d", count(ctx))
+	})
+	err := ListenAndServe("supervised", conf)
+	failed, ok := err.(DeviceErrors)
+	if !ok || len(failed) != 1 || failed[0].Device != "broken" || failed[0].Err.Error() != "broken 4" {
+		t.Errorf("err = %v", err)
+	}
+	for typ, expected := range map[string]int{
+		"test_flaky":  3,
+		"test_repeat": 3,
+		"test_broken": 4,
+	} {
+		if runs[typ] != expected {
+			t.Errorf("%s ran %d times, expected %d", typ, runs[typ], expected)
+		}
+	}
+}
+
+func TestApp(t *testing.T) {
+	conf := `
+version = 1.0
+apps
+    lifecycle
+        devices
+            queue
+                type = zmq_queue
+                sockets
+                    frontend
+                        type = ROUTER
+                        bind = tcp://127.0.0.1:5564
+                    backend
+                        type = DEALER
+                        bind = tcp://127.0.0.1:5565
+            once
+                type = test_once`
+	DeviceFunc("test_once", func(ctx *DeviceContext) {})
+	app, err := NewApp("lifecycle", conf)
+	if err != nil {
+		t.Fatalf("failed to create app: %s", err)
+	}
+	defer app.Close()
+	devices := app.Devices()
+	if len(devices) != 2 || devices[0].Name() != "once" || devices[1].Name() != "queue" {
+		t.Fatalf("devices = %v", devices)
+	}
+	for _, dev := range devices {
+		if status, _ := dev.Status(); status != DeviceIdle {
+			t.Errorf("%s: status = %v", dev.Name(), status)
+		}
+	}
+	if err = app.Start(); err != nil {
+		t.Fatalf("failed to start: %s", err)
+	}
+	if err = app.Start(); err == nil {
+		t.Errorf("started twice")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if status, _ := devices[0].Status(); status != DeviceStopped {
+		t.Errorf("once: status = %v", status)
+	}
+	if status, _ := devices[1].Status(); status != DeviceRunning {
+		t.Errorf("queue: status = %v", status)
+	}
+	app.Stop()
+	if err = app.Wait(); err != nil {
+		t.Errorf("err = %v", err)
+	}
+	if status, _ := devices[1].Status(); status != DeviceStopped {
+		t.Errorf("queue: status = %v", status)
+	}
+}
+
+func TestApp_StartAfterStop(t *testing.T) {
+	conf := `
+version = 1.0
+apps
+    halted
+        devices
+            main
+                type = test_halted`
+	DeviceFunc("test_halted", func(ctx *DeviceContext) {
+		<-ctx.Done()
+	})
+	app, err := NewApp("halted", conf)
+	if err != nil {
+		t.Fatalf("failed to create app: %s", err)
+	}
+	app.Stop()
+	if err = app.Start(); err == nil {
+		t.Errorf("started after stopping")
+	} else if err.Error() != "app already stopped." {
+		t.Errorf("err = %v", err)
+	}
+	if status, _ := app.devices["main"].Status(); status != DeviceIdle {
+		t.Errorf("main: status = %v", status)
+	}
+}
+
+func TestApp_StopDevice(t *testing.T) {
+	conf := `
+version = 1.0
+apps
+    maintenance
+        devices
+            queue
+                type = zmq_streamer
+                sockets
+                    frontend
+                        type = PULL
+                        bind = tcp://127.0.0.1:5566
+                    backend
+                        type = PUSH
+                        bind = tcp://127.0.0.1:5567
+            other
+                type = test_other`
+	DeviceFunc("test_other", func(ctx *DeviceContext) {
+		<-ctx.Done()
+	})
+	app, err := NewApp("maintenance", conf)
+	if err != nil {
+		t.Fatalf("failed to create app: %s", err)
+	}
+	defer app.Close()
+	if err = app.StartDevice("queue"); err != nil {
+		t.Fatalf("failed to start queue: %s", err)
+	}
+	if err = app.StartDevice("queue"); err == nil {
+		t.Errorf("started queue twice")
+	}
+	if err = app.StartDevice("other"); err != nil {
+		t.Fatalf("failed to start other: %s", err)
+	}
+	queue, other := app.devices["queue"], app.devices["other"]
+	if err = app.StopDevice("queue"); err != nil {
+		t.Fatalf("failed to stop queue: %s", err)
+	}
+	if status, _ := queue.Status(); status != DeviceStopped {
+		t.Errorf("queue: status = %v", status)
+	}
+	if status, _ := other.Status(); status != DeviceRunning {
+		t.Errorf("other: status = %v", status)
+	}
+	if err = app.StopDevice("queue"); err == nil {
+		t.Errorf("stopped queue twice")
+	}
+	if err = app.RestartDevice("queue"); err != nil {
+		t.Fatalf("failed to restart queue: %s", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if status, _ := queue.Status(); status != DeviceRunning {
+		t.Errorf("queue: status = %v", status)
+	}
+	app.Stop()
+	if err = app.Wait(); err != nil {
+		t.Errorf("err = %v", err)
+	}
+	if err = app.StartDevice("queue"); err == nil {
+		t.Errorf("started queue after stopping app")
+	}
+}
+
+func TestApp_RestartDevice(t *testing.T) {
+	conf := `
+version = 1.0
+apps
+    restartable
+        devices
+            main
+                type = test_restartable`
+	DeviceFunc("test_restartable", func(ctx *DeviceContext) {
+		<-ctx.Done()
+	})
+	app, err := NewApp("restartable", conf)
+	if err != nil {
+		t.Fatalf("failed to create app: %s", err)
+	}
+	defer app.Close()
+	if err = app.Start(); err != nil {
+		t.Fatalf("failed to start: %s", err)
+	}
+	waited := make(chan error, 1)
+	go func() {
+		waited <- app.Wait()
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if err = app.RestartDevice("main"); err != nil {
+		t.Fatalf("failed to restart: %s", err)
+	}
+	select {
+	case err = <-waited:
+		t.Fatalf("Wait returned during restart: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	app.Stop()
+	select {
+	case err = <-waited:
+		if err != nil {
+			t.Errorf("err = %v", err)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatalf("timed out :-(")
+	}
+}
+
+func TestApp_Reload(t *testing.T) {
+	before := `
+version = 1.0
+apps
+    reloadable
+        devices
+            same
+                type = test_reload
+                sockets
+                    out
+                        type = PUSH
+                        bind = tcp://127.0.0.1:5568
+            changed
+                type = test_reload
+                sockets
+                    out
+                        type = PUSH
+                        bind = tcp://127.0.0.1:5569
+            removed
+                type = test_reload`
+	after := `
+version = 1.0
+apps
+    reloadable
+        devices
+            same
+                type = test_reload
+                sockets
+                    out
+                        type = PUSH
+                        bind = tcp://127.0.0.1:5568
+            changed
+                type = test_reload
+                sockets
+                    out
+                        type = PUSH
+                        bind = tcp://127.0.0.1:5570
+            added
+                type = test_reload`
+	DeviceFunc("test_reload", func(ctx *DeviceContext) {
+		if _, ok := ctx.sockets["out"]; ok {
+			ctx.MustOpen("out")
+		}
+		<-ctx.Done()
+	})
+	app, err := NewApp("reloadable", before)
+	if err != nil {
+		t.Fatalf("failed to create app: %s", err)
+	}
+	defer app.Close()
+	if err = app.Start(); err != nil {
+		t.Fatalf("failed to start: %s", err)
+	}
+	same, changed, removed := app.devices["same"], app.devices["changed"], app.devices["removed"]
+	time.Sleep(10 * time.Millisecond)
+	app.ReloadOnHangup(after)
+	syscall.Kill(os.Getpid(), syscall.SIGHUP)
+	for i := 0; i < 100; i++ {
+		if _, err = app.device("added"); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	devices := app.Devices()
+	if len(devices) != 3 || devices[0].Name() != "added" || devices[1].Name() != "changed" || devices[2].Name() != "same" {
+		t.Fatalf("devices = %v", devices)
+	}
+	if devices[2] != same {
+		t.Errorf("same was replaced")
+	}
+	if devices[1] == changed {
+		t.Errorf("changed was not replaced")
+	}
+	time.Sleep(10 * time.Millisecond)
+	for _, dev := range devices {
+		if status, err := dev.Status(); status != DeviceRunning {
+			t.Errorf("%s: status = %v (%v)", dev.Name(), status, err)
+		}
+	}
+	for _, dev := range []*DeviceContext{changed, removed} {
+		if status, err := dev.Status(); status != DeviceStopped {
+			t.Errorf("%s: status = %v (%v)", dev.Name(), status, err)
+		}
+	}
+	if err = app.Reload(`
+version = 1.0
+apps
+    reloadable
+        context
+            iothreads = 2`); err == nil {
+		t.Errorf("reloaded with different iothreads")
+	}
+	app.Stop()
+	if err = app.Wait(); err != nil {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestApp_Reload_Wait(t *testing.T) {
+	conf := `
+version = 1.0
+apps
+    lingering
+        context
+            linger = %d
+        devices
+            main
+                type = test_lingering`
+	DeviceFunc("test_lingering", func(ctx *DeviceContext) {
+		<-ctx.Done()
+	})
+	app, err := NewApp("lingering", fmt.Sprintf(conf, 0))
+	if err != nil {
+		t.Fatalf("failed to create app: %s", err)
+	}
+	defer app.Close()
+	if err = app.Start(); err != nil {
+		t.Fatalf("failed to start: %s", err)
+	}
+	waited := make(chan error, 1)
+	go func() {
+		waited <- app.Wait()
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if err = app.Reload(fmt.Sprintf(conf, 100)); err != nil {
+		t.Fatalf("failed to reload: %s", err)
+	}
+	select {
+	case err = <-waited:
+		t.Fatalf("Wait returned during reload: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if status, _ := app.devices["main"].Status(); status != DeviceRunning {
+		t.Errorf("main: status = %v", status)
+	}
+	app.Stop()
+	select {
+	case err = <-waited:
+		if err != nil {
+			t.Errorf("err = %v", err)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatalf("timed out :-(")
+	}
+}
+
+func TestApp_Reload_Blocking(t *testing.T) {
+	conf := `
+version = 1.0
+apps
+    blocking
+        context
+            linger = 0
+        devices
+            main
+                type = test_reload_block
+                sockets
+                    in
+                        type = PULL
+                        bind = tcp://127.0.0.1:%d`
+	DeviceFunc("test_reload_block", func(ctx *DeviceContext) {
+		in := ctx.MustOpen("in")
+		in.Recv(0)
+	})
+	app, err := NewApp("blocking", fmt.Sprintf(conf, 5571))
+	if err != nil {
+		t.Fatalf("failed to create app: %s", err)
+	}
+	if err = app.Start(); err != nil {
+		t.Fatalf("failed to start: %s", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	reloaded := make(chan error, 1)
+	go func() {
+		reloaded <- app.Reload(fmt.Sprintf(conf, 5572))
+	}()
+	time.Sleep(10 * time.Millisecond)
+	stopped := make(chan bool, 1)
+	go func() {
+		app.Stop()
+		stopped <- true
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(1 * time.Second):
+		t.Fatalf("stop timed out :-(")
+	}
+	select {
+	case err = <-reloaded:
+		if err != nil {
+			t.Errorf("err = %v", err)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatalf("reload timed out :-(")
+	}
+	if devices := app.Devices(); len(devices) != 1 || devices[0].running() {
+		t.Errorf("devices = %v", devices)
+	}
+}
+
 func Example() {
 	defaults := `
 version = 0.1